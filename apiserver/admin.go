@@ -0,0 +1,81 @@
+package apiserver
+
+import (
+	"async_api/store"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserPriorityRequest struct {
+	MaxPriority string `json:"max_priority"`
+}
+
+func (r UserPriorityRequest) Validate() error {
+	if r.MaxPriority == "" {
+		return errors.New("max_priority is required")
+	}
+	return store.ValidatePriority(r.MaxPriority)
+}
+
+type UserResponse struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	MaxPriority string    `json:"max_priority"`
+	IsAdmin     bool      `json:"is_admin"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func newUserResponse(user *store.User) UserResponse {
+	return UserResponse{
+		ID:          user.ID,
+		Email:       user.Email,
+		MaxPriority: user.MaxPriority,
+		IsAdmin:     user.IsAdmin,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// setUserPriorityHandler changes the highest priority of the reports a user may create, it is restricted to admins
+func (s *ApiServer) setUserPriorityHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		admin, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+		if !admin.IsAdmin {
+			return NewErrWithStatus(http.StatusForbidden, fmt.Errorf("only admins can change the priority of users"))
+		}
+
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid user id %w", err))
+		}
+
+		req, err := decode[UserPriorityRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid request %w", err))
+		}
+
+		user, err := s.store.Users.SetMaxPriority(r.Context(), userID, req.MaxPriority)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[UserResponse]{
+			Message: "successfully updated user priority",
+			Data:    ptr(newUserResponse(user)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package apiserver
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Interval of the comments sent on idle event streams, so proxies do not close them
+const eventsKeepaliveInterval = 15 * time.Second
+
+// eventStream writes Server-Sent Events to a response
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// newEventStream writes the headers of an event stream response
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	rc := http.NewResponseController(w)
+	// event streams outlive the write timeout of regular responses
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, fmt.Errorf("failed to clear write deadline: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush event stream: %w", err)
+	}
+
+	return &eventStream{w: w, rc: rc}, nil
+}
+
+// send writes one event, data must be a single line such as compact JSON
+func (s *eventStream) send(event string, data []byte) error {
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// comment writes a line that clients ignore
+func (s *eventStream) comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// reportEventsHandler streams a "report" event whenever the status or the progress of a report changes
+// and ends the stream once the report is finished. The report is read again on every notification
+// from the database and every EventsPollInterval, in case a notification was missed, so the stream
+// sees the changes made by the workers whichever api server instance it is connected to.
+func (s *ApiServer) reportEventsHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		reportID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid report id %w", err))
+		}
+
+		report, err := s.store.Reports.ByPrimaryKey(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		stream, err := newEventStream(w)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		// the response has started, from here on errors are only logged
+		logger := s.logger.With("report_id", reportID, "user_id", user.ID)
+
+		sub := s.events.SubscribeReport(reportID)
+		defer sub.Close()
+
+		poll := time.NewTicker(s.config.EventsPollInterval)
+		defer poll.Stop()
+		keepalive := time.NewTicker(eventsKeepaliveInterval)
+		defer keepalive.Stop()
+
+		var last []byte
+		for {
+			data, err := json.Marshal(newReportResponse(report))
+			if err != nil {
+				logger.Error("failed to encode report event", "error", err)
+				return nil
+			}
+			if !bytes.Equal(data, last) {
+				if err := stream.send("report", data); err != nil {
+					return nil
+				}
+				last = data
+			}
+			if report.Finished() {
+				return nil
+			}
+
+			select {
+			case <-r.Context().Done():
+				return nil
+			case <-s.done:
+				return nil
+			case <-keepalive.C:
+				if err := stream.comment("keepalive"); err != nil {
+					return nil
+				}
+				continue
+			case <-sub.C:
+			case <-poll.C:
+			}
+
+			if report, err = s.store.Reports.ByPrimaryKey(r.Context(), user.ID, reportID); err != nil {
+				if r.Context().Err() == nil {
+					logger.Error("failed to fetch report for event stream", "error", err)
+				}
+				return nil
+			}
+		}
+	})
+}
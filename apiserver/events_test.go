@@ -0,0 +1,22 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	stream, err := newEventStream(w)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	require.True(t, w.Flushed)
+
+	require.NoError(t, stream.send("report", []byte(`{"status":"queued"}`)))
+	require.NoError(t, stream.comment("keepalive"))
+	require.Equal(t, "event: report\ndata: {\"status\":\"queued\"}\n\n: keepalive\n\n", w.Body.String())
+}
@@ -28,7 +28,8 @@ func handler(f func(w http.ResponseWriter, r *http.Request) error) http.HandlerF
 			if e, ok := err.(*ErrWithStatus); ok {
 				status = e.status
 				msg = http.StatusText(e.status)
-				if status == http.StatusBadRequest || status == http.StatusConflict {
+				switch status {
+				case http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests:
 					msg = e.err.Error()
 				}
 				slog.Error("error executing handler", "error", err, "status", status, "message", msg)
@@ -67,3 +68,7 @@ func decode[T Validator](r *http.Request) (T, error) {
 
 	return t, nil
 }
+
+func ptr[T any](v T) *T {
+	return &v
+}
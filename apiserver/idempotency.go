@@ -0,0 +1,158 @@
+package apiserver
+
+import (
+	"async_api/config"
+	"async_api/store"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// Requests with an idempotency key are read into memory to be fingerprinted
+	maxIdempotentBodySize = 1 << 20
+)
+
+// responseRecorder passes the response through and keeps a copy of it to be stored with the idempotency key
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotencyFingerprintKey derives the key of the request fingerprints from the jwt secret
+func idempotencyFingerprintKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("idempotency request fingerprint"))
+	return mac.Sum(nil)
+}
+
+// requestFingerprint identifies a request by its method, path and body. It is keyed, since bodies like
+// the one of a signup contain a password that a plain hash would expose to offline guessing.
+func requestFingerprint(key []byte, r *http.Request, body []byte) string {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewIdempotencyMiddleware makes retries of a request with the same Idempotency-Key header safe. The first request
+// runs and its response is stored per user, a retry with the same body replays it and a retry with another body is
+// rejected with 422. Server errors and 429 are not stored, so the request runs again when it is retried. Requests
+// without the header are passed through. Requests without a user share one namespace, their keys have to be
+// uuids so unrelated clients do not collide.
+func NewIdempotencyMiddleware(config *config.Config, logger *slog.Logger, idempotencyStore *store.IdempotencyStore) func(next http.Handler) http.Handler {
+	fingerprintKey := idempotencyFingerprintKey(config.JwtSecret)
+	return func(next http.Handler) http.Handler {
+		return handler(func(w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return nil
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength))
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			if err != nil {
+				return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("failed to read request body %w", err))
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// signups have no user yet, their keys share the nil uuid
+			userID := uuid.Nil
+			if user, ok := UserFromContext(r.Context()); ok {
+				userID = user.ID
+			} else if _, err := uuid.Parse(key); err != nil {
+				return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("idempotency key of unauthenticated requests must be a uuid"))
+			}
+			logger := logger.With("user_id", userID, "idempotency_key", key)
+
+			fingerprint := requestFingerprint(fingerprintKey, r, body)
+			idempotencyKey, acquired, err := idempotencyStore.Begin(r.Context(), userID, key, fingerprint, config.IdempotencyKeyTTL, config.IdempotencyLockTimeout)
+			if err != nil {
+				return NewErrWithStatus(http.StatusInternalServerError, err)
+			}
+			if !acquired {
+				if idempotencyKey.Fingerprint != fingerprint {
+					return NewErrWithStatus(http.StatusUnprocessableEntity, fmt.Errorf("idempotency key was already used with a different request"))
+				}
+				if !idempotencyKey.Completed() {
+					return NewErrWithStatus(http.StatusConflict, fmt.Errorf("a request with this idempotency key is in progress"))
+				}
+				return replay(w, idempotencyKey)
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			// the response is already sent, the key is settled even if the client went away
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+				if err := idempotencyStore.Release(ctx, userID, key); err != nil {
+					logger.Error("failed to release idempotency key", "error", err)
+				}
+				return nil
+			}
+
+			headers, err := json.Marshal(rec.Header())
+			if err != nil {
+				logger.Error("failed to encode response headers", "error", err)
+				headers = nil
+			}
+			if _, err := idempotencyStore.Complete(ctx, userID, key, rec.status, headers, rec.body.Bytes()); err != nil {
+				logger.Error("failed to store idempotent response", "error", err)
+			}
+			return nil
+		})
+	}
+}
+
+// replay writes the stored response of a completed idempotency key
+func replay(w http.ResponseWriter, idempotencyKey *store.IdempotencyKey) error {
+	var headers http.Header
+	if err := json.Unmarshal(idempotencyKey.ResponseHeaders, &headers); err != nil {
+		return NewErrWithStatus(http.StatusInternalServerError, fmt.Errorf("failed to decode stored response headers: %w", err))
+	}
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(*idempotencyKey.StatusCode)
+	if _, err := w.Write(idempotencyKey.ResponseBody); err != nil {
+		slog.Error("failed to write replayed response", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package apiserver_test
+
+import (
+	"async_api/apiserver"
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	dataStore := store.New(env.DB)
+	user := &store.User{ID: uuid.New()}
+
+	calls := 0
+	status := http.StatusInternalServerError
+	middleware := apiserver.NewIdempotencyMiddleware(env.Config, slog.Default(), dataStore.Idempotency)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Call", strings.Repeat("i", calls))
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message":"created"}`))
+	}))
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(body))
+		req = req.WithContext(apiserver.ContextWithUser(context.Background(), user))
+		if key != "" {
+			req.Header.Set(apiserver.IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// server errors are not stored
+	w := send("key-1", `{"report_type":"sample"}`)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	status = http.StatusCreated
+	w = send("key-1", `{"report_type":"sample"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "ii", w.Header().Get("X-Call"))
+	require.Empty(t, w.Header().Get(apiserver.IdempotentReplayedHeader))
+	require.Equal(t, 2, calls)
+
+	w = send("key-1", `{"report_type":"sample"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "ii", w.Header().Get("X-Call"))
+	require.Equal(t, "true", w.Header().Get(apiserver.IdempotentReplayedHeader))
+	require.Equal(t, `{"message":"created"}`, w.Body.String())
+	require.Equal(t, 2, calls)
+
+	w = send("key-1", `{"report_type":"other"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.Contains(t, w.Body.String(), "different request")
+	require.Equal(t, 2, calls)
+
+	// requests without a key are not deduplicated
+	send("", `{"report_type":"sample"}`)
+	send("", `{"report_type":"sample"}`)
+	require.Equal(t, 4, calls)
+
+	w = send(strings.Repeat("k", 256), `{"report_type":"sample"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, 4, calls)
+
+	// requests without a user share a namespace, so their keys have to be uuids
+	signup := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(`{"email":"test@test.com","password":"testingpassword"}`))
+		req.Header.Set(apiserver.IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	w = signup("1")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, 4, calls)
+	key := uuid.NewString()
+	require.Equal(t, http.StatusCreated, signup(key).Code)
+	require.Equal(t, "true", signup(key).Header().Get(apiserver.IdempotentReplayedHeader))
+	require.Equal(t, 5, calls)
+
+	// the stored fingerprint is keyed, it does not reveal the body
+	stored, _, err := dataStore.Idempotency.Begin(context.Background(), uuid.Nil, key, "", env.Config.IdempotencyKeyTTL, env.Config.IdempotencyLockTimeout)
+	require.NoError(t, err)
+	plain := sha256.Sum256([]byte("POST\n/auth/signup\n" + `{"email":"test@test.com","password":"testingpassword"}`))
+	require.NotEqual(t, hex.EncodeToString(plain[:]), stored.Fingerprint)
+}
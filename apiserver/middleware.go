@@ -25,10 +25,16 @@ func ContextWithUser(ctx context.Context, user *store.User) context.Context {
 	return context.WithValue(ctx, userCtxKey{}, user)
 }
 
+func UserFromContext(ctx context.Context) (*store.User, bool) {
+	user, ok := ctx.Value(userCtxKey{}).(*store.User)
+	return user, ok
+}
+
 func NewAuthMiddleware(JwtManager *JwtManager, userStore *store.UserStore) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.URL.Path, "/auth") {
+			// downloads are authorized by the signature of the presigned url
+			if strings.HasPrefix(r.URL.Path, "/auth") || strings.HasPrefix(r.URL.Path, "/downloads/") {
 				next.ServeHTTP(w, r)
 				return
 			}
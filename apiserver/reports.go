@@ -0,0 +1,442 @@
+package apiserver
+
+import (
+	"async_api/blobstore"
+	"async_api/reports"
+	"async_api/store"
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateReportRequest struct {
+	ReportType string          `json:"report_type"`
+	Parameters json.RawMessage `json:"parameters"`
+	// Priority is low, normal or high, normal if empty
+	Priority string `json:"priority"`
+}
+
+func (r CreateReportRequest) Validate() error {
+	if r.ReportType == "" {
+		return errors.New("report_type is required")
+	}
+	if r.Priority != "" {
+		if err := store.ValidatePriority(r.Priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ReportResponse struct {
+	ID                   uuid.UUID           `json:"id"`
+	ReportType           string              `json:"report_type"`
+	Parameters           json.RawMessage     `json:"parameters"`
+	Status               string              `json:"status"`
+	Priority             string              `json:"priority"`
+	DownloadUrl          *string             `json:"download_url,omitempty"`
+	OutputSize           *int64              `json:"output_size,omitempty"`
+	OutputChecksum       *string             `json:"output_checksum,omitempty"`
+	DownloadUrlExpiresAt *time.Time          `json:"download_url_expires_at,omitempty"`
+	ErrorMessage         *string             `json:"error_message,omitempty"`
+	CreatedAt            time.Time           `json:"created_at"`
+	StartedAt            *time.Time          `json:"started_at,omitempty"`
+	FailedAt             *time.Time          `json:"failed_at,omitempty"`
+	CompletedAt          *time.Time          `json:"completed_at,omitempty"`
+	Attempts             int                 `json:"attempts"`
+	AttemptErrors        store.AttemptErrors `json:"attempt_errors,omitempty"`
+	DeadLetteredAt       *time.Time          `json:"dead_lettered_at,omitempty"`
+	CancelledAt          *time.Time          `json:"cancelled_at,omitempty"`
+	Progress             ProgressResponse    `json:"progress"`
+	OutputExpiredAt      *time.Time          `json:"output_expired_at,omitempty"`
+	// Deduplicated is set on reports that reuse or wait for the result of an identical report
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+type ProgressResponse struct {
+	Percent       int        `json:"percent"`
+	Stage         *string    `json:"stage,omitempty"`
+	RowsProcessed int64      `json:"rows_processed"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+func newReportResponse(report *store.Report) ReportResponse {
+	return ReportResponse{
+		ID:                   report.ID,
+		ReportType:           report.ReportType,
+		Parameters:           report.Parameters,
+		Status:               report.Status(),
+		Priority:             report.Priority,
+		DownloadUrl:          report.DownloadUrl,
+		OutputSize:           report.OutputSize,
+		OutputChecksum:       report.OutputChecksum,
+		DownloadUrlExpiresAt: report.DownloadUrlExpiresAt,
+		ErrorMessage:         report.ErrorMessage,
+		CreatedAt:            report.CreatedAt,
+		StartedAt:            report.StartedAt,
+		FailedAt:             report.FailedAt,
+		CompletedAt:          report.CompletedAt,
+		Attempts:             report.Attempts,
+		AttemptErrors:        report.AttemptErrors,
+		DeadLetteredAt:       report.DeadLetteredAt,
+		CancelledAt:          report.CancelledAt,
+		Progress: ProgressResponse{
+			Percent:       report.ProgressPercent,
+			Stage:         report.ProgressStage,
+			RowsProcessed: report.RowsProcessed,
+			UpdatedAt:     report.ProgressUpdatedAt,
+		},
+		OutputExpiredAt: report.OutputExpiredAt,
+		Deduplicated:    report.SourceReportID != nil,
+	}
+}
+
+type ReportTypeResponse struct {
+	Name       string              `json:"name"`
+	Parameters []reports.ParamSpec `json:"parameters"`
+}
+
+func (s *ApiServer) listReportTypesHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		resp := []ReportTypeResponse{}
+		for _, name := range s.registry.Names() {
+			generator, _ := s.registry.Lookup(name)
+			params := generator.Schema()
+			if params == nil {
+				params = []reports.ParamSpec{}
+			}
+			resp = append(resp, ReportTypeResponse{
+				Name:       name,
+				Parameters: params,
+			})
+		}
+
+		if err := encode(ApiResponse[[]ReportTypeResponse]{
+			Data: &resp,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// createOptions are the quota of the reports a user creates through the api and, for report types
+// whose output only depends on the parameters, how identical reports share their results
+func (s *ApiServer) createOptions(generator reports.ReportGenerator, priority string) store.CreateOptions {
+	opts := store.CreateOptions{
+		Priority: priority,
+		Quota:    store.ReportQuota{MaxActive: s.config.ReportQuotaActive, MaxDaily: s.config.ReportQuotaDaily},
+	}
+	if reports.SharesResults(generator) {
+		opts.CacheTTL = s.config.ReportCacheTTL
+		opts.Coalesce = s.config.ReportCoalesce
+	}
+	return opts
+}
+
+func (s *ApiServer) createReportHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		req, err := decode[CreateReportRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid request %w", err))
+		}
+
+		priority := cmp.Or(req.Priority, store.PriorityNormal)
+		if !store.PriorityAllowed(priority, user.MaxPriority) {
+			return NewErrWithStatus(http.StatusForbidden, fmt.Errorf("priority %q is above the highest priority allowed for the user, %q", priority, user.MaxPriority))
+		}
+
+		generator, ok := s.registry.Lookup(req.ReportType)
+		if !ok {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("unknown report_type %q, valid report types: %s", req.ReportType, strings.Join(s.registry.Names(), ", ")))
+		}
+
+		params, err := reports.DecodeParams(generator, req.Parameters)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+		// store the validated parameters so defaults filled in by Validate are kept
+		parameters, err := json.Marshal(params)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		report, err := s.store.Reports.Create(r.Context(), user.ID, req.ReportType, parameters, s.createOptions(generator, priority))
+		if err != nil {
+			var quotaErr *store.QuotaExceededError
+			if errors.As(err, &quotaErr) {
+				retryAfter := quotaErr.RetryAfter
+				if retryAfter <= 0 {
+					retryAfter = s.config.ReportQuotaRetryAfter
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				return NewErrWithStatus(http.StatusTooManyRequests, quotaErr)
+			}
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		if err := encode(ApiResponse[ReportResponse]{
+			Message: "successfully created report",
+			Data:    ptr(newReportResponse(report)),
+		}, http.StatusCreated, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) getReportHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		reportID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid report id %w", err))
+		}
+
+		report, err := s.store.Reports.ByPrimaryKey(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[ReportResponse]{
+			Data: ptr(newReportResponse(report)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) listReportsHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		reports, err := s.store.Reports.ByUserID(r.Context(), user.ID)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		resp := make([]ReportResponse, 0, len(reports))
+		for i := range reports {
+			resp = append(resp, newReportResponse(&reports[i]))
+		}
+
+		if err := encode(ApiResponse[[]ReportResponse]{
+			Data: &resp,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// requeueReportHandler puts a dead lettered report back in the queue with a fresh set of attempts
+func (s *ApiServer) requeueReportHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		reportID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid report id %w", err))
+		}
+
+		report, err := s.store.Reports.ByPrimaryKey(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if report.Status() != store.ReportStatusDeadLettered {
+			return NewErrWithStatus(http.StatusConflict, fmt.Errorf("only dead lettered reports can be requeued, report is %s", report.Status()))
+		}
+
+		report, err = s.store.Reports.Requeue(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				// requeued concurrently by another request
+				status = http.StatusConflict
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[ReportResponse]{
+			Message: "successfully requeued report",
+			Data:    ptr(newReportResponse(report)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// cancelReportHandler cancels a queued or running report. A running report is stopped
+// by its worker, which notices the cancellation on its next heartbeat.
+func (s *ApiServer) cancelReportHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		reportID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid report id %w", err))
+		}
+
+		report, err := s.store.Reports.ByPrimaryKey(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if status := report.Status(); status != store.ReportStatusQueued && status != store.ReportStatusRunning {
+			return NewErrWithStatus(http.StatusConflict, fmt.Errorf("only queued or running reports can be cancelled, report is %s", status))
+		}
+
+		report, err = s.store.Reports.Cancel(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				// finished or cancelled concurrently
+				status = http.StatusConflict
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[ReportResponse]{
+			Message: "successfully cancelled report",
+			Data:    ptr(newReportResponse(report)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+type DownloadUrlResponse struct {
+	DownloadUrl string    `json:"download_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// downloadUrlHandler returns the download url of a completed report, minting a new one once the old one expired
+func (s *ApiServer) downloadUrlHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		reportID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid report id %w", err))
+		}
+
+		report, err := s.store.Reports.ByPrimaryKey(r.Context(), user.ID, reportID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if report.OutputExpiredAt != nil {
+			return NewErrWithStatus(http.StatusGone, fmt.Errorf("report output expired"))
+		}
+		if report.CompletedAt == nil || report.OutputFilePath == nil {
+			return NewErrWithStatus(http.StatusConflict, fmt.Errorf("report is not completed"))
+		}
+
+		if report.DownloadUrl == nil || report.DownloadUrlExpiresAt == nil || report.DownloadUrlExpiresAt.Before(time.Now()) {
+			expiresAt := time.Now().Add(s.config.DownloadUrlLifetime)
+			downloadUrl, err := s.blobStore.PresignGet(r.Context(), *report.OutputFilePath, s.config.DownloadUrlLifetime)
+			if err != nil {
+				return NewErrWithStatus(http.StatusInternalServerError, err)
+			}
+			report, err = s.store.Reports.UpdateDownloadUrl(r.Context(), user.ID, reportID, downloadUrl, expiresAt)
+			if err != nil {
+				return NewErrWithStatus(http.StatusInternalServerError, err)
+			}
+		}
+
+		if err := encode(ApiResponse[DownloadUrlResponse]{
+			Data: &DownloadUrlResponse{
+				DownloadUrl: *report.DownloadUrl,
+				ExpiresAt:   *report.DownloadUrlExpiresAt,
+			},
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// downloadHandler serves the presigned urls of the local blob store backend
+func (s *ApiServer) downloadHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		localStore, ok := s.blobStore.(*blobstore.LocalStore)
+		if !ok {
+			return NewErrWithStatus(http.StatusNotFound, fmt.Errorf("downloads are served by the blob store backend"))
+		}
+
+		key := r.PathValue("key")
+		if err := localStore.Verify(key, r.URL.Query()); err != nil {
+			return NewErrWithStatus(http.StatusForbidden, err)
+		}
+
+		object, err := localStore.Get(r.Context(), key)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, blobstore.ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+		defer object.Close()
+
+		w.Header().Set("Content-Type", cmp.Or(mime.TypeByExtension(path.Ext(key)), "application/octet-stream"))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(key)))
+		if _, err := io.Copy(w, object); err != nil {
+			s.logger.Error("failed to write download", "error", err, "key", key)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,264 @@
+package apiserver
+
+import (
+	"async_api/reports"
+	"async_api/scheduler"
+	"async_api/store"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ScheduleRequest struct {
+	ReportType     string          `json:"report_type"`
+	Parameters     json.RawMessage `json:"parameters"`
+	CronExpression string          `json:"cron_expression"`
+	// Timezone is the IANA time zone of the cron expression, UTC by default
+	Timezone string `json:"timezone"`
+	// Enabled is true by default
+	Enabled *bool `json:"enabled"`
+}
+
+func (r ScheduleRequest) Validate() error {
+	if r.ReportType == "" {
+		return errors.New("report_type is required")
+	}
+	if r.CronExpression == "" {
+		return errors.New("cron_expression is required")
+	}
+	return nil
+}
+
+type ScheduleResponse struct {
+	ID             uuid.UUID       `json:"id"`
+	ReportType     string          `json:"report_type"`
+	Parameters     json.RawMessage `json:"parameters"`
+	CronExpression string          `json:"cron_expression"`
+	Timezone       string          `json:"timezone"`
+	Enabled        bool            `json:"enabled"`
+	NextRunAt      time.Time       `json:"next_run_at"`
+	LastRunAt      *time.Time      `json:"last_run_at,omitempty"`
+	LastReportID   *uuid.UUID      `json:"last_report_id,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func newScheduleResponse(schedule *store.ReportSchedule) ScheduleResponse {
+	return ScheduleResponse{
+		ID:             schedule.ID,
+		ReportType:     schedule.ReportType,
+		Parameters:     schedule.Parameters,
+		CronExpression: schedule.CronExpression,
+		Timezone:       schedule.Timezone,
+		Enabled:        schedule.Enabled,
+		NextRunAt:      schedule.NextRunAt,
+		LastRunAt:      schedule.LastRunAt,
+		LastReportID:   schedule.LastReportID,
+		CreatedAt:      schedule.CreatedAt,
+		UpdatedAt:      schedule.UpdatedAt,
+	}
+}
+
+// scheduleParams validates the report type, parameters, cron expression and time zone of req
+// the same way a report is validated and computes the next run from now
+func (s *ApiServer) scheduleParams(req ScheduleRequest) (store.ScheduleParams, error) {
+	generator, ok := s.registry.Lookup(req.ReportType)
+	if !ok {
+		return store.ScheduleParams{}, fmt.Errorf("unknown report_type %q, valid report types: %s", req.ReportType, strings.Join(s.registry.Names(), ", "))
+	}
+
+	params, err := reports.DecodeParams(generator, req.Parameters)
+	if err != nil {
+		return store.ScheduleParams{}, err
+	}
+	parameters, err := json.Marshal(params)
+	if err != nil {
+		return store.ScheduleParams{}, err
+	}
+
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	spec, err := scheduler.ParseSchedule(req.CronExpression, req.Timezone)
+	if err != nil {
+		return store.ScheduleParams{}, err
+	}
+	nextRunAt := spec.Next(time.Now())
+	if nextRunAt.IsZero() {
+		return store.ScheduleParams{}, fmt.Errorf("cron expression %q never matches", req.CronExpression)
+	}
+
+	return store.ScheduleParams{
+		ReportType:     req.ReportType,
+		Parameters:     parameters,
+		CronExpression: req.CronExpression,
+		Timezone:       req.Timezone,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+		NextRunAt:      nextRunAt,
+	}, nil
+}
+
+func (s *ApiServer) createScheduleHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		req, err := decode[ScheduleRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid request %w", err))
+		}
+
+		params, err := s.scheduleParams(req)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		schedule, err := s.store.Schedules.Create(r.Context(), user.ID, params)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		if err := encode(ApiResponse[ScheduleResponse]{
+			Message: "successfully created report schedule",
+			Data:    ptr(newScheduleResponse(schedule)),
+		}, http.StatusCreated, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) listSchedulesHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		schedules, err := s.store.Schedules.ByUserID(r.Context(), user.ID)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		resp := make([]ScheduleResponse, 0, len(schedules))
+		for _, schedule := range schedules {
+			resp = append(resp, newScheduleResponse(&schedule))
+		}
+
+		if err := encode(ApiResponse[[]ScheduleResponse]{
+			Data: &resp,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) getScheduleHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		scheduleID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid schedule id %w", err))
+		}
+
+		schedule, err := s.store.Schedules.ByPrimaryKey(r.Context(), user.ID, scheduleID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[ScheduleResponse]{
+			Data: ptr(newScheduleResponse(schedule)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// updateScheduleHandler replaces a schedule, its next run is computed again from now
+func (s *ApiServer) updateScheduleHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		scheduleID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid schedule id %w", err))
+		}
+
+		req, err := decode[ScheduleRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid request %w", err))
+		}
+
+		params, err := s.scheduleParams(req)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		schedule, err := s.store.Schedules.Update(r.Context(), user.ID, scheduleID, params)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[ScheduleResponse]{
+			Message: "successfully updated report schedule",
+			Data:    ptr(newScheduleResponse(schedule)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) deleteScheduleHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		scheduleID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid schedule id %w", err))
+		}
+
+		if err := s.store.Schedules.Delete(r.Context(), user.ID, scheduleID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[struct{}]{
+			Message: "successfully deleted report schedule",
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
@@ -1,7 +1,10 @@
 package apiserver
 
 import (
+	"async_api/blobstore"
 	"async_api/config"
+	"async_api/events"
+	"async_api/reports"
 	"async_api/store"
 	"context"
 	"log/slog"
@@ -16,14 +19,23 @@ type ApiServer struct {
 	logger     *slog.Logger
 	store      *store.Store
 	jwtManager *JwtManager
+	blobStore  blobstore.Store
+	registry   *reports.Registry
+	events     *events.Listener
+	// done is closed when the server shuts down, it ends the long lived event streams
+	done chan struct{}
 }
 
-func New(config *config.Config, logger *slog.Logger, store *store.Store, jwtManager *JwtManager) *ApiServer {
+func New(config *config.Config, logger *slog.Logger, store *store.Store, jwtManager *JwtManager, blobStore blobstore.Store, registry *reports.Registry, events *events.Listener) *ApiServer {
 	return &ApiServer{
 		config:     config,
 		logger:     logger,
 		store:      store,
 		jwtManager: jwtManager,
+		blobStore:  blobStore,
+		registry:   registry,
+		events:     events,
+		done:       make(chan struct{}),
 	}
 }
 
@@ -34,10 +46,33 @@ func (s *ApiServer) ping(w http.ResponseWriter, r *http.Request) {
 
 func (s *ApiServer) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
+	idempotent := NewIdempotencyMiddleware(s.config, s.logger, s.store.Idempotency)
 	mux.HandleFunc("GET /ping", s.ping)
-	mux.HandleFunc("POST /auth/signup", s.signupHandler())
+	mux.Handle("POST /auth/signup", idempotent(s.signupHandler()))
 	mux.HandleFunc("POST /auth/signin", s.signinHandler())
 	mux.HandleFunc("POST /auth/refresh", s.tokenRefreshHandler())
+	mux.HandleFunc("GET /report-types", s.listReportTypesHandler())
+	mux.Handle("POST /reports", idempotent(s.createReportHandler()))
+	mux.HandleFunc("GET /reports", s.listReportsHandler())
+	mux.HandleFunc("GET /reports/{id}", s.getReportHandler())
+	mux.HandleFunc("GET /reports/{id}/events", s.reportEventsHandler())
+	mux.HandleFunc("POST /reports/{id}/download-url", s.downloadUrlHandler())
+	mux.HandleFunc("POST /reports/{id}/requeue", s.requeueReportHandler())
+	mux.HandleFunc("POST /reports/{id}/cancel", s.cancelReportHandler())
+	mux.HandleFunc("GET /downloads/{key...}", s.downloadHandler())
+	mux.HandleFunc("PUT /admin/users/{id}/priority", s.setUserPriorityHandler())
+	mux.HandleFunc("POST /schedules", s.createScheduleHandler())
+	mux.HandleFunc("GET /schedules", s.listSchedulesHandler())
+	mux.HandleFunc("GET /schedules/{id}", s.getScheduleHandler())
+	mux.HandleFunc("PUT /schedules/{id}", s.updateScheduleHandler())
+	mux.HandleFunc("DELETE /schedules/{id}", s.deleteScheduleHandler())
+	mux.HandleFunc("POST /webhooks", s.createWebhookHandler())
+	mux.HandleFunc("GET /webhooks", s.listWebhooksHandler())
+	mux.HandleFunc("GET /webhooks/{id}", s.getWebhookHandler())
+	mux.HandleFunc("PUT /webhooks/{id}", s.updateWebhookHandler())
+	mux.HandleFunc("DELETE /webhooks/{id}", s.deleteWebhookHandler())
+	mux.HandleFunc("POST /webhooks/{id}/rotate-secret", s.rotateWebhookSecretHandler())
+	mux.HandleFunc("GET /webhooks/{id}/deliveries", s.listWebhookDeliveriesHandler())
 
 	middleware := NewLoggerMiddleware(s.logger)
 	middleware = NewAuthMiddleware(s.jwtManager, s.store.Users)
@@ -45,6 +80,9 @@ func (s *ApiServer) Start(ctx context.Context) error {
 		Addr:    net.JoinHostPort(s.config.ApiServerHost, s.config.ApiServerPort),
 		Handler: middleware(mux),
 	}
+	server.RegisterOnShutdown(func() {
+		close(s.done)
+	})
 
 	go func() {
 		s.logger.Info("starting server", "port", s.config.ApiServerPort)
@@ -0,0 +1,329 @@
+package apiserver
+
+import (
+	"async_api/store"
+	"async_api/webhooks"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Number of deliveries returned by the delivery log of a webhook
+const webhookDeliveriesLimit = 50
+
+type WebhookRequest struct {
+	Url string `json:"url"`
+}
+
+func (r WebhookRequest) Validate() error {
+	if r.Url == "" {
+		return errors.New("url is required")
+	}
+	u, err := url.Parse(r.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https url")
+	}
+	return nil
+}
+
+// checkWebhookUrl rejects urls pointing to internal addresses, the dispatcher would refuse to deliver to them
+func (s *ApiServer) checkWebhookUrl(rawUrl string) error {
+	if s.config.WebhookAllowPrivateNetworks {
+		return nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	return webhooks.CheckHost(u.Hostname())
+}
+
+type WebhookResponse struct {
+	ID  uuid.UUID `json:"id"`
+	Url string    `json:"url"`
+	// Secret is only returned when it is created or rotated
+	Secret                  string     `json:"secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+	UpdatedAt               time.Time  `json:"updated_at"`
+}
+
+func newWebhookResponse(webhook *store.Webhook) WebhookResponse {
+	resp := WebhookResponse{
+		ID:        webhook.ID,
+		Url:       webhook.Url,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+	}
+	if webhook.PreviousSecretExpiresAt != nil && webhook.PreviousSecretExpiresAt.After(time.Now()) {
+		resp.PreviousSecretExpiresAt = webhook.PreviousSecretExpiresAt
+	}
+	return resp
+}
+
+type WebhookDeliveryResponse struct {
+	ID             int64      `json:"id"`
+	ReportID       uuid.UUID  `json:"report_id"`
+	Event          string     `json:"event"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int       `json:"last_status_code,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	FailedAt       *time.Time `json:"failed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func newWebhookDeliveryResponse(delivery *store.WebhookDelivery) WebhookDeliveryResponse {
+	resp := WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		ReportID:       delivery.ReportID,
+		Event:          delivery.Event,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+		FailedAt:       delivery.FailedAt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+	if delivery.DeliveredAt == nil && delivery.FailedAt == nil {
+		resp.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return resp
+}
+
+func (s *ApiServer) createWebhookHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		req, err := decode[WebhookRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid request %w", err))
+		}
+		if err := s.checkWebhookUrl(req.Url); err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		webhook, err := s.store.Webhooks.Create(r.Context(), user.ID, req.Url)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		resp := newWebhookResponse(webhook)
+		resp.Secret = webhook.Secret
+		if err := encode(ApiResponse[WebhookResponse]{
+			Message: "successfully created webhook",
+			Data:    &resp,
+		}, http.StatusCreated, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) listWebhooksHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		webhooks, err := s.store.Webhooks.ByUserID(r.Context(), user.ID)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		resp := make([]WebhookResponse, 0, len(webhooks))
+		for _, webhook := range webhooks {
+			resp = append(resp, newWebhookResponse(&webhook))
+		}
+
+		if err := encode(ApiResponse[[]WebhookResponse]{
+			Data: &resp,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) getWebhookHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		webhookID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid webhook id %w", err))
+		}
+
+		webhook, err := s.store.Webhooks.ByPrimaryKey(r.Context(), user.ID, webhookID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[WebhookResponse]{
+			Data: ptr(newWebhookResponse(webhook)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) updateWebhookHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		webhookID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid webhook id %w", err))
+		}
+
+		req, err := decode[WebhookRequest](r)
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid request %w", err))
+		}
+		if err := s.checkWebhookUrl(req.Url); err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, err)
+		}
+
+		webhook, err := s.store.Webhooks.UpdateUrl(r.Context(), user.ID, webhookID, req.Url)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[WebhookResponse]{
+			Message: "successfully updated webhook",
+			Data:    ptr(newWebhookResponse(webhook)),
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+func (s *ApiServer) deleteWebhookHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		webhookID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid webhook id %w", err))
+		}
+
+		if err := s.store.Webhooks.Delete(r.Context(), user.ID, webhookID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		if err := encode(ApiResponse[struct{}]{
+			Message: "successfully deleted webhook",
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// rotateWebhookSecretHandler replaces the secret of a webhook and returns the new one. The previous
+// secret keeps signing the payloads for WebhookSecretRotationGrace, so receivers can switch without downtime.
+func (s *ApiServer) rotateWebhookSecretHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		webhookID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid webhook id %w", err))
+		}
+
+		webhook, err := s.store.Webhooks.RotateSecret(r.Context(), user.ID, webhookID, s.config.WebhookSecretRotationGrace)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		resp := newWebhookResponse(webhook)
+		resp.Secret = webhook.Secret
+		if err := encode(ApiResponse[WebhookResponse]{
+			Message: "successfully rotated webhook secret",
+			Data:    &resp,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
+
+// listWebhookDeliveriesHandler returns the latest deliveries of a webhook with the outcome of their last attempt
+func (s *ApiServer) listWebhookDeliveriesHandler() http.HandlerFunc {
+	return handler(func(w http.ResponseWriter, r *http.Request) error {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			return NewErrWithStatus(http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		}
+
+		webhookID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			return NewErrWithStatus(http.StatusBadRequest, fmt.Errorf("invalid webhook id %w", err))
+		}
+
+		if _, err := s.store.Webhooks.ByPrimaryKey(r.Context(), user.ID, webhookID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusNotFound
+			}
+			return NewErrWithStatus(status, err)
+		}
+
+		deliveries, err := s.store.Webhooks.Deliveries(r.Context(), user.ID, webhookID, webhookDeliveriesLimit)
+		if err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+
+		resp := make([]WebhookDeliveryResponse, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			resp = append(resp, newWebhookDeliveryResponse(&delivery))
+		}
+
+		if err := encode(ApiResponse[[]WebhookDeliveryResponse]{
+			Data: &resp,
+		}, http.StatusOK, w); err != nil {
+			return NewErrWithStatus(http.StatusInternalServerError, err)
+		}
+		return nil
+	})
+}
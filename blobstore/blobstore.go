@@ -0,0 +1,56 @@
+package blobstore
+
+import (
+	"async_api/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+const (
+	BackendS3    = "s3"
+	BackendLocal = "local"
+)
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Store keeps report outputs. Keys are slash separated paths like "reports/<user_id>/<id>.csv".
+type Store interface {
+	// Put stores the object read from r until EOF, r may be a stream of unknown length. A failed
+	// read fails the Put and no partial object is left behind.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns ErrNotFound if there is no object with the key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete does not fail if there is no object with the key
+	Delete(ctx context.Context, key string) error
+	// Stat returns ErrNotFound if there is no object with the key
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// PresignGet returns a URL that allows downloading the object without credentials until ttl passes
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// NewFromConfig creates the Store selected by conf.BlobStoreBackend
+func NewFromConfig(ctx context.Context, conf *config.Config) (Store, error) {
+	switch conf.BlobStoreBackend {
+	case BackendS3:
+		client, err := NewS3Client(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(client, conf.S3Bucket), nil
+	case BackendLocal:
+		baseUrl := "http://" + net.JoinHostPort(conf.ApiServerHost, conf.ApiServerPort)
+		return NewLocalStore(conf.ReportsDir, baseUrl, []byte(conf.DownloadUrlSecret))
+	default:
+		return nil, fmt.Errorf("unknown blob store backend %q", conf.BlobStoreBackend)
+	}
+}
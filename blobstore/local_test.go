@@ -0,0 +1,94 @@
+package blobstore_test
+
+import (
+	"async_api/blobstore"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost:5000", []byte("secret"))
+	require.NoError(t, err)
+
+	const key = "reports/user/report.csv"
+	_, err = s.Stat(ctx, key)
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+	_, err = s.Get(ctx, key)
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("a,b,c\n")))
+
+	info, err := s.Stat(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, key, info.Key)
+	require.Equal(t, int64(6), info.Size)
+
+	r, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "a,b,c\n", string(data))
+
+	require.NoError(t, s.Delete(ctx, key))
+	require.NoError(t, s.Delete(ctx, key))
+	_, err = s.Stat(ctx, key)
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+
+	require.Error(t, s.Put(ctx, "../escape", strings.NewReader("x")))
+
+	// a stream failing halfway leaves no object behind
+	pr, pw := io.Pipe()
+	go func() {
+		io.WriteString(pw, "a,b,c\n")
+		pw.CloseWithError(errors.New("generator failed"))
+	}()
+	require.ErrorContains(t, s.Put(ctx, key, pr), "generator failed")
+	_, err = s.Stat(ctx, key)
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+}
+
+func TestNewLocalStoreRequiresSecret(t *testing.T) {
+	_, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost:5000", nil)
+	require.Error(t, err)
+}
+
+func TestLocalStorePresignGet(t *testing.T) {
+	ctx := context.Background()
+	s, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost:5000", []byte("secret"))
+	require.NoError(t, err)
+
+	const key = "reports/user/report.csv"
+	rawUrl, err := s.PresignGet(ctx, key, time.Minute)
+	require.NoError(t, err)
+
+	u, err := url.Parse(rawUrl)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000", u.Host)
+	require.Equal(t, "/downloads/"+key, u.Path)
+	require.NoError(t, s.Verify(key, u.Query()))
+
+	require.ErrorIs(t, s.Verify("reports/user/other.csv", u.Query()), blobstore.ErrInvalidSignature)
+
+	tampered := u.Query()
+	tampered.Set("expires", "9999999999")
+	require.ErrorIs(t, s.Verify(key, tampered), blobstore.ErrInvalidSignature)
+
+	other, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost:5000", []byte("other secret"))
+	require.NoError(t, err)
+	require.ErrorIs(t, other.Verify(key, u.Query()), blobstore.ErrInvalidSignature)
+
+	expiredUrl, err := s.PresignGet(ctx, key, -time.Minute)
+	require.NoError(t, err)
+	u, err = url.Parse(expiredUrl)
+	require.NoError(t, err)
+	require.ErrorIs(t, s.Verify(key, u.Query()), blobstore.ErrInvalidSignature)
+}
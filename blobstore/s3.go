@@ -0,0 +1,125 @@
+package blobstore
+
+import (
+	"async_api/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// Objects are uploaded in parts of uploadPartSize, an upload holds at most uploadConcurrency parts in memory
+	uploadPartSize    = 8 << 20
+	uploadConcurrency = 2
+)
+
+func NewS3Client(ctx context.Context, conf *config.Config) (*s3.Client, error) {
+	awsConf, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+
+	return s3.NewFromConfig(awsConf, func(o *s3.Options) {
+		if conf.S3LocalstackEndpoint != "" {
+			o.BaseEndpoint = aws.String(conf.S3LocalstackEndpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+// S3Store keeps objects in an S3 (or S3-compatible) bucket
+type S3Store struct {
+	client        *s3.Client
+	presignClient *s3.PresignClient
+	uploader      *manager.Uploader
+	bucket        string
+}
+
+func NewS3Store(client *s3.Client, bucket string) *S3Store {
+	return &S3Store{
+		client:        client,
+		presignClient: s3.NewPresignClient(client),
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+			u.PartSize = uploadPartSize
+			u.Concurrency = uploadConcurrency
+		}),
+		bucket: bucket,
+	}
+}
+
+// Put streams r to a multipart upload, objects smaller than a part are uploaded in a single request.
+// The upload is aborted if reading r fails.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	if _, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	}); err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("failed to get object %s: %w", key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// HEAD responses have no body, so a missing key surfaces as NotFound rather than NoSuchKey
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("failed to stat object %s: %w", key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object %s: %w", key, err)
+	}
+	return req.URL, nil
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"async_api/apiserver"
+	"async_api/blobstore"
 	"async_api/config"
+	"async_api/events"
+	"async_api/outbox"
+	"async_api/queue"
+	"async_api/reports"
 	"async_api/store"
+	"async_api/webhooks"
 	"context"
 	"log"
 	"log/slog"
@@ -35,8 +41,26 @@ func run() error {
 
 	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
 	logger := slog.New(jsonHandler)
+
+	reportQueue, err := queue.NewFromConfig(ctx, conf, logger, db)
+	if err != nil {
+		return err
+	}
+
+	blobStore, err := blobstore.NewFromConfig(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	relay := outbox.NewRelay(conf, logger, dataStore, reportQueue)
+	go relay.Start(ctx)
+	listener := events.NewListener(db, logger)
+	go listener.Start(ctx)
+	dispatcher := webhooks.NewDispatcher(conf, logger, dataStore, listener)
+	go dispatcher.Start(ctx)
+
 	jwtManager := apiserver.NewJwtManager(conf)
-	server := apiserver.New(conf, logger, dataStore, jwtManager)
+	server := apiserver.New(conf, logger, dataStore, jwtManager, blobStore, reports.DefaultRegistry(), listener)
 	if err := server.Start(ctx); err != nil {
 		return err
 	}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"async_api/blobstore"
+	"async_api/config"
+	"async_api/leader"
+	"async_api/outbox"
+	"async_api/queue"
+	"async_api/reports"
+	"async_api/retention"
+	"async_api/scheduler"
+	"async_api/store"
+	"async_api/worker"
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	conf, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	db, err := store.NewPostgresDB(conf)
+	if err != nil {
+		return err
+	}
+	dataStore := store.New(db)
+
+	jsonHandler := slog.NewJSONHandler(os.Stdout, nil)
+	logger := slog.New(jsonHandler)
+
+	reportQueue, err := queue.NewFromConfig(ctx, conf, logger, db)
+	if err != nil {
+		return err
+	}
+
+	blobStore, err := blobstore.NewFromConfig(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	relay := outbox.NewRelay(conf, logger, dataStore, reportQueue)
+	go relay.Start(ctx)
+
+	// the reaper, the scheduler and the retention sweeper run on one worker at a time
+	reaper := worker.NewReaper(conf, logger, dataStore)
+	go leader.NewElector(conf, logger, db, "reaper").Run(ctx, reaper.Start)
+
+	reportScheduler := scheduler.New(conf, logger, dataStore)
+	go leader.NewElector(conf, logger, db, "scheduler").Run(ctx, reportScheduler.Start)
+
+	sweeper := retention.NewSweeper(conf, logger, dataStore, blobStore)
+	go leader.NewElector(conf, logger, db, "retention").Run(ctx, sweeper.Start)
+
+	reportWorker := worker.New(conf, logger, dataStore, reportQueue, blobStore, reports.DefaultRegistry())
+	if err := reportWorker.Start(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 )
@@ -14,25 +15,70 @@ const (
 )
 
 type Config struct {
-	ApiServerHost           string `env:"APISERVER_HOST"`
-	ApiServerPort           string `env:"APISERVER_PORT"`
-	DBName                  string `env:"DB_NAME"`
-	DBHost                  string `env:"DB_HOST"`
-	DBPort                  string `env:"DB_PORT"`
-	DBPortTest              string `env:"DB_PORT_TEST"`
-	DBUser                  string `env:"DB_USER"`
-	DBPassword              string `env:"DB_PASSWORD"`
-	DBSSLMode               string `env:"DB_SSL_MODE"`
-	DBSchema                string `env:"DB_SCHEMA"`
-	Env                     Env    `env:"ENV" envDefault:"dev"`
-	JwtSecret               string `env:"JWT_SECRET"`
-	JwtAccessTokenLifetime  string `env:"JWT_ACCESS_TOKEN_LIFETIME"`
-	JwtRefreshTokenLifetime string `env:"JWT_REFRESH_TOKEN_LIFETIME"`
-	ProjectRoot             string `env:"PROJECT_ROOT"`
-	S3LocalstackEndpoint    string `env:"S3_LOCALSTACK_ENDPOINT"`
-	S3Bucket                string `env:"S3_BUCKET"`
-	SQSLocalstackEndpoint   string `env:"SQS_LOCALSTACK_ENDPOINT"`
-	SQSQueue                string `env:"SQS_QUEUE"`
+	ApiServerHost               string                   `env:"APISERVER_HOST"`
+	ApiServerPort               string                   `env:"APISERVER_PORT"`
+	BlobStoreBackend            string                   `env:"BLOBSTORE_BACKEND" envDefault:"s3"`
+	DBName                      string                   `env:"DB_NAME"`
+	DBHost                      string                   `env:"DB_HOST"`
+	DBPort                      string                   `env:"DB_PORT"`
+	DBPortTest                  string                   `env:"DB_PORT_TEST"`
+	DBUser                      string                   `env:"DB_USER"`
+	DBPassword                  string                   `env:"DB_PASSWORD"`
+	DBSSLMode                   string                   `env:"DB_SSL_MODE"`
+	DBSchema                    string                   `env:"DB_SCHEMA"`
+	DownloadUrlLifetime         time.Duration            `env:"DOWNLOAD_URL_LIFETIME" envDefault:"1h"`
+	DownloadUrlSecret           string                   `env:"DOWNLOAD_URL_SECRET"`
+	Env                         Env                      `env:"ENV" envDefault:"dev"`
+	EventsPollInterval          time.Duration            `env:"EVENTS_POLL_INTERVAL" envDefault:"10s"`
+	IdempotencyKeyTTL           time.Duration            `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+	IdempotencyLockTimeout      time.Duration            `env:"IDEMPOTENCY_LOCK_TIMEOUT" envDefault:"1m"`
+	JwtSecret                   string                   `env:"JWT_SECRET"`
+	JwtAccessTokenLifetime      string                   `env:"JWT_ACCESS_TOKEN_LIFETIME"`
+	JwtRefreshTokenLifetime     string                   `env:"JWT_REFRESH_TOKEN_LIFETIME"`
+	LeaderRenewInterval         time.Duration            `env:"LEADER_RENEW_INTERVAL" envDefault:"5s"`
+	LeaderRetryInterval         time.Duration            `env:"LEADER_RETRY_INTERVAL" envDefault:"10s"`
+	OutboxRelayInterval         time.Duration            `env:"OUTBOX_RELAY_INTERVAL" envDefault:"1s"`
+	ProjectRoot                 string                   `env:"PROJECT_ROOT"`
+	QueueBackend                string                   `env:"QUEUE_BACKEND" envDefault:"sqs"`
+	QueuePollInterval           time.Duration            `env:"QUEUE_POLL_INTERVAL" envDefault:"1s"`
+	QueuePriorityWeights        map[string]int           `env:"QUEUE_PRIORITY_WEIGHTS" envDefault:"high:6,normal:3,low:1"`
+	QueueVisibilityTimeout      time.Duration            `env:"QUEUE_VISIBILITY_TIMEOUT" envDefault:"5m"`
+	ReaperInterval              time.Duration            `env:"REAPER_INTERVAL" envDefault:"30s"`
+	ReportCacheTTL              time.Duration            `env:"REPORT_CACHE_TTL" envDefault:"10m"`
+	ReportCoalesce              bool                     `env:"REPORT_COALESCE" envDefault:"true"`
+	ReportMaxAttempts           int                      `env:"REPORT_MAX_ATTEMPTS" envDefault:"3"`
+	ReportQuotaActive           int                      `env:"REPORT_QUOTA_ACTIVE" envDefault:"10"`
+	ReportQuotaDaily            int                      `env:"REPORT_QUOTA_DAILY" envDefault:"500"`
+	ReportQuotaRetryAfter       time.Duration            `env:"REPORT_QUOTA_RETRY_AFTER" envDefault:"30s"`
+	ReportRetryBaseDelay        time.Duration            `env:"REPORT_RETRY_BASE_DELAY" envDefault:"10s"`
+	ReportRetryMaxDelay         time.Duration            `env:"REPORT_RETRY_MAX_DELAY" envDefault:"5m"`
+	ReportsDir                  string                   `env:"REPORTS_DIR" envDefault:"/tmp/async_api/reports"`
+	RetentionArchive            bool                     `env:"RETENTION_ARCHIVE" envDefault:"false"`
+	RetentionInterval           time.Duration            `env:"RETENTION_INTERVAL" envDefault:"1h"`
+	RetentionOutputTTL          time.Duration            `env:"RETENTION_OUTPUT_TTL" envDefault:"720h"`
+	RetentionOutputTTLs         map[string]time.Duration `env:"RETENTION_OUTPUT_TTLS"`
+	RetentionRowTTL             time.Duration            `env:"RETENTION_ROW_TTL" envDefault:"2160h"`
+	RetentionRowTTLs            map[string]time.Duration `env:"RETENTION_ROW_TTLS"`
+	S3LocalstackEndpoint        string                   `env:"S3_LOCALSTACK_ENDPOINT"`
+	S3Bucket                    string                   `env:"S3_BUCKET"`
+	SchedulerInterval           time.Duration            `env:"SCHEDULER_INTERVAL" envDefault:"15s"`
+	SchedulerMaxCatchUpRuns     int                      `env:"SCHEDULER_MAX_CATCH_UP_RUNS" envDefault:"24"`
+	SchedulerMissedRuns         string                   `env:"SCHEDULER_MISSED_RUNS" envDefault:"skip"`
+	SQSLocalstackEndpoint       string                   `env:"SQS_LOCALSTACK_ENDPOINT"`
+	SQSQueue                    string                   `env:"SQS_QUEUE"`
+	WebhookAllowPrivateNetworks bool                     `env:"WEBHOOK_ALLOW_PRIVATE_NETWORKS" envDefault:"false"`
+	WebhookDispatchInterval     time.Duration            `env:"WEBHOOK_DISPATCH_INTERVAL" envDefault:"5s"`
+	WebhookMaxAttempts          int                      `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	WebhookRetryBaseDelay       time.Duration            `env:"WEBHOOK_RETRY_BASE_DELAY" envDefault:"30s"`
+	WebhookRetryMaxDelay        time.Duration            `env:"WEBHOOK_RETRY_MAX_DELAY" envDefault:"1h"`
+	WebhookSecretRotationGrace  time.Duration            `env:"WEBHOOK_SECRET_ROTATION_GRACE" envDefault:"24h"`
+	WebhookTimeout              time.Duration            `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WorkerConcurrency           int                      `env:"WORKER_CONCURRENCY" envDefault:"4"`
+	WorkerDrainTimeout          time.Duration            `env:"WORKER_DRAIN_TIMEOUT" envDefault:"30s"`
+	WorkerHeartbeatInterval     time.Duration            `env:"WORKER_HEARTBEAT_INTERVAL" envDefault:"10s"`
+	WorkerHeartbeatTimeout      time.Duration            `env:"WORKER_HEARTBEAT_TIMEOUT" envDefault:"1m"`
+	WorkerJobTimeout            time.Duration            `env:"WORKER_JOB_TIMEOUT" envDefault:"10m"`
+	WorkerProgressInterval      time.Duration            `env:"WORKER_PROGRESS_INTERVAL" envDefault:"2s"`
 }
 
 func New() (*Config, error) {
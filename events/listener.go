@@ -0,0 +1,158 @@
+package events
+
+import (
+	"async_api/store"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+const (
+	// Number of events buffered per subscription, events for a full subscription are dropped
+	subscriptionBufferSize = 64
+	reconnectBaseDelay     = time.Second
+	reconnectMaxDelay      = 30 * time.Second
+)
+
+// Listener receives the report notifications sent by the store over a dedicated database
+// connection and fans them out to in-process subscribers. Notifications sent while the
+// connection is down are lost, subscribers that must not miss a state read the report.
+type Listener struct {
+	db     *sql.DB
+	logger *slog.Logger
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewListener(db *sql.DB, logger *slog.Logger) *Listener {
+	return &Listener{
+		db:            db,
+		logger:        logger,
+		subscriptions: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives the report events accepted by its filter until it is closed
+type Subscription struct {
+	C <-chan store.ReportEvent
+
+	c        chan store.ReportEvent
+	filter   func(store.ReportEvent) bool
+	listener *Listener
+}
+
+// Close stops the delivery of events to the subscription
+func (s *Subscription) Close() {
+	s.listener.mu.Lock()
+	defer s.listener.mu.Unlock()
+	delete(s.listener.subscriptions, s)
+}
+
+// Subscribe returns a subscription to the events accepted by filter, a nil filter accepts every event
+func (l *Listener) Subscribe(filter func(store.ReportEvent) bool) *Subscription {
+	c := make(chan store.ReportEvent, subscriptionBufferSize)
+	sub := &Subscription{C: c, c: c, filter: filter, listener: l}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subscriptions[sub] = struct{}{}
+	return sub
+}
+
+// SubscribeReport returns a subscription to the events of one report
+func (l *Listener) SubscribeReport(reportID uuid.UUID) *Subscription {
+	return l.Subscribe(func(event store.ReportEvent) bool {
+		return event.ReportID == reportID
+	})
+}
+
+// Start listens for report notifications until ctx is cancelled.
+// A dropped connection is replaced after a backoff delay.
+func (l *Listener) Start(ctx context.Context) error {
+	l.logger.Info("starting report events listener", "channel", store.ReportEventsChannel)
+	delay := reconnectBaseDelay
+	for {
+		connectedAt := time.Now()
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// a connection that was up for a while gets reconnected right away
+		if time.Since(connectedAt) > reconnectMaxDelay {
+			delay = reconnectBaseDelay
+		}
+		l.logger.Error("report events listener disconnected, reconnecting", "error", err, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, reconnectMaxDelay)
+	}
+}
+
+// listen takes a connection out of the pool and publishes its notifications until it fails
+func (l *Listener) listen(ctx context.Context) error {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer conn.Close()
+
+	var listenErr error
+	err = conn.Raw(func(driverConn any) error {
+		listenErr = l.receive(ctx, driverConn.(*stdlib.Conn).Conn())
+		// the connection still listens on the channel, it must not go back to the pool
+		return driver.ErrBadConn
+	})
+	if listenErr != nil {
+		return listenErr
+	}
+	return err
+}
+
+func (l *Listener) receive(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{store.ReportEventsChannel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", store.ReportEventsChannel, err)
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+
+		var event store.ReportEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			l.logger.Error("failed to decode report event", "error", err, "payload", notification.Payload)
+			continue
+		}
+		l.publish(event)
+	}
+}
+
+// publish hands event to the matching subscriptions without blocking on slow ones
+func (l *Listener) publish(event store.ReportEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for sub := range l.subscriptions {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			l.logger.Warn("report events subscription is full, dropping event", "report_id", event.ReportID)
+		}
+	}
+}
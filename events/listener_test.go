@@ -0,0 +1,40 @@
+package events
+
+import (
+	"async_api/store"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerPublish(t *testing.T) {
+	listener := NewListener(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	reportID := uuid.New()
+	all := listener.Subscribe(nil)
+	one := listener.SubscribeReport(reportID)
+
+	first := store.ReportEvent{UserID: uuid.New(), ReportID: reportID, Status: store.ReportStatusRunning}
+	second := store.ReportEvent{UserID: uuid.New(), ReportID: uuid.New(), Status: store.ReportStatusQueued}
+	listener.publish(first)
+	listener.publish(second)
+
+	require.Equal(t, first, <-all.C)
+	require.Equal(t, second, <-all.C)
+	require.Equal(t, first, <-one.C)
+	require.Empty(t, one.C)
+
+	one.Close()
+	listener.publish(first)
+	require.Empty(t, one.C)
+	require.Equal(t, first, <-all.C)
+
+	// a full subscription does not block the others
+	for range subscriptionBufferSize + 1 {
+		listener.publish(second)
+	}
+	require.Len(t, all.C, subscriptionBufferSize)
+}
@@ -52,6 +52,6 @@ func (te *TestEnv) SetupDB(t *testing.T) func(t *testing.T) {
 }
 
 func (te *TestEnv) TeardownDB(t *testing.T) {
-	_, err := te.DB.Exec(fmt.Sprintf("TRUNCATE TABLE %s;", strings.Join([]string{"users", "refresh_tokens", "reports"}, ", ")))
+	_, err := te.DB.Exec(fmt.Sprintf("TRUNCATE TABLE %s;", strings.Join([]string{"users", "refresh_tokens", "reports", "jobs", "outbox", "webhooks", "webhook_deliveries", "report_schedules", "reports_archive", "idempotency_keys"}, ", ")))
 	require.NoError(t, err)
 }
@@ -0,0 +1,154 @@
+package leader
+
+import (
+	"async_api/config"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLeadershipLost is the cause of a leader context cancelled because the lock could not be renewed
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// Elector makes sure a loop runs on a single instance. The leader holds a Postgres session level
+// advisory lock on a dedicated connection, which the database releases if the leader goes away.
+// The lease is renewed by checking the connection every LeaderRenewInterval; when that fails the
+// leader's context is cancelled and the other instances can take over.
+type Elector struct {
+	db     *sql.DB
+	logger *slog.Logger
+	name   string
+	key    int64
+
+	renewInterval time.Duration
+	retryInterval time.Duration
+	leading       atomic.Bool
+}
+
+// NewElector returns an elector for the loop called name, electors with the same name compete for the same lock
+func NewElector(config *config.Config, logger *slog.Logger, db *sql.DB, name string) *Elector {
+	return &Elector{
+		db:            db,
+		logger:        logger.With("leader_election", name),
+		name:          name,
+		key:           lockKey(name),
+		renewInterval: config.LeaderRenewInterval,
+		retryInterval: config.LeaderRetryInterval,
+	}
+}
+
+// lockKey maps a name to the bigint key of its advisory lock
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("async_api:" + name))
+	return int64(h.Sum64())
+}
+
+// IsLeader reports whether the elector currently holds the lock
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns for leadership until ctx is cancelled and runs fn whenever it is the leader. The context
+// passed to fn is cancelled with ErrLeadershipLost as its cause when leadership is lost. If fn returns, the
+// lock is released and the elector campaigns again, so loops such as Reaper.Start can be passed as is.
+func (e *Elector) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	for {
+		if err := e.campaign(ctx, fn); err != nil && ctx.Err() == nil {
+			e.logger.Error("leader election failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(e.retryInterval):
+		}
+	}
+}
+
+// campaign tries to take the lock once and runs fn for as long as it is held
+func (e *Elector) campaign(ctx context.Context, fn func(ctx context.Context) error) error {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1);`, e.key).Scan(&acquired); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+	if !acquired {
+		return conn.Close()
+	}
+	defer e.release(conn)
+
+	e.logger.Info("acquired leadership")
+	e.leading.Store(true)
+	defer e.leading.Store(false)
+
+	leaderCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(e.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("leader loop failed: %w", err)
+			}
+			return nil
+		case <-ticker.C:
+			if err := e.renew(ctx, conn); err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				e.logger.Error("lost leadership", "error", err)
+				cancel(ErrLeadershipLost)
+				<-done
+				return nil
+			}
+		case <-ctx.Done():
+			cancel(nil)
+			<-done
+			return nil
+		}
+	}
+}
+
+// renew checks that the session holding the lock is still alive
+func (e *Elector) renew(ctx context.Context, conn *sql.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, e.renewInterval)
+	defer cancel()
+	var one int
+	if err := conn.QueryRowContext(ctx, `SELECT 1;`).Scan(&one); err != nil {
+		return fmt.Errorf("failed to renew leadership: %w", err)
+	}
+	return nil
+}
+
+// release unlocks the lock and returns the connection to the pool. A connection that cannot be
+// unlocked is discarded, closing its session releases the lock.
+func (e *Elector) release(conn *sql.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.renewInterval)
+	defer cancel()
+	var released bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_advisory_unlock($1);`, e.key).Scan(&released); err != nil || !released {
+		conn.Raw(func(any) error {
+			return driver.ErrBadConn
+		})
+	}
+	e.logger.Info("released leadership")
+}
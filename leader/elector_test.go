@@ -0,0 +1,69 @@
+package leader_test
+
+import (
+	"async_api/config"
+	"async_api/fixture"
+	"async_api/leader"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestElector(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := &config.Config{LeaderRenewInterval: 20 * time.Millisecond, LeaderRetryInterval: 20 * time.Millisecond}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	first := leader.NewElector(conf, logger, env.DB, "test")
+	second := leader.NewElector(conf, logger, env.DB, "test")
+
+	firstCtx, stopFirst := context.WithCancel(ctx)
+	defer stopFirst()
+	leading := make(chan context.Context, 2)
+	go first.Run(firstCtx, func(ctx context.Context) error {
+		leading <- ctx
+		<-ctx.Done()
+		return nil
+	})
+	require.Eventually(t, first.IsLeader, time.Second, 10*time.Millisecond)
+	<-leading
+
+	go second.Run(ctx, func(ctx context.Context) error {
+		leading <- ctx
+		<-ctx.Done()
+		return nil
+	})
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, second.IsLeader())
+
+	// the second elector takes over once the first one stops
+	stopFirst()
+	require.Eventually(t, second.IsLeader, time.Second, 10*time.Millisecond)
+	leaderCtx := <-leading
+
+	// killing the session of the leader cancels its context, only the session holding the lock of this
+	// election is killed since other tests use advisory locks on the same database
+	_, err := env.DB.ExecContext(ctx, `SELECT pg_terminate_backend(pid) FROM pg_locks
+	WHERE locktype = 'advisory' AND granted AND objsubid = 1 AND ((classid::bigint << 32) | objid::bigint) = $1;`, leader.LockKey("test"))
+	require.NoError(t, err)
+	select {
+	case <-leaderCtx.Done():
+		require.ErrorIs(t, context.Cause(leaderCtx), leader.ErrLeadershipLost)
+	case <-time.After(time.Second):
+		t.Fatal("leader context was not cancelled")
+	}
+
+	// and the elector becomes leader again on a new connection
+	select {
+	case <-leading:
+	case <-time.After(time.Second):
+		t.Fatal("elector did not become leader again")
+	}
+}
@@ -0,0 +1,4 @@
+package leader
+
+// LockKey exposes the advisory lock key of an election to the tests
+var LockKey = lockKey
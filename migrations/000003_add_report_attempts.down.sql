@@ -0,0 +1,3 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS dead_lettered_at;
+ALTER TABLE reports DROP COLUMN IF EXISTS attempt_errors;
+ALTER TABLE reports DROP COLUMN IF EXISTS attempts;
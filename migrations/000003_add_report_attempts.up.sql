@@ -0,0 +1,3 @@
+ALTER TABLE reports ADD COLUMN attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE reports ADD COLUMN attempt_errors JSONB NOT NULL DEFAULT '[]'::jsonb; -- error of every failed attempt
+ALTER TABLE reports ADD COLUMN dead_lettered_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS reports_running_heartbeat_idx;
+ALTER TABLE reports DROP COLUMN IF EXISTS heartbeat_at;
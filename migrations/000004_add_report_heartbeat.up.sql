@@ -0,0 +1,4 @@
+ALTER TABLE reports ADD COLUMN heartbeat_at TIMESTAMPTZ; -- refreshed by the worker processing the report
+
+CREATE INDEX reports_running_heartbeat_idx ON reports (heartbeat_at)
+	WHERE started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE jobs (
+	id BIGSERIAL PRIMARY KEY,
+	queue VARCHAR NOT NULL,
+	payload JSONB NOT NULL,
+	receive_count INT NOT NULL DEFAULT 0,
+	visible_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- jobs are claimed once visible, claiming hides them for the visibility timeout
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX jobs_queue_visible_at_idx ON jobs (queue, visible_at);
@@ -0,0 +1,2 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS dedup_key;
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,9 @@
+-- jobs waiting to be published to the report queue, written in the same transaction as the report change
+CREATE TABLE outbox (
+	id BIGSERIAL PRIMARY KEY,
+	dedup_key UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(), -- sent with the job so redeliveries can be recognized
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE jobs ADD COLUMN dedup_key VARCHAR UNIQUE;
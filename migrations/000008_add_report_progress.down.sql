@@ -0,0 +1,4 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS progress_updated_at;
+ALTER TABLE reports DROP COLUMN IF EXISTS rows_processed;
+ALTER TABLE reports DROP COLUMN IF EXISTS progress_stage;
+ALTER TABLE reports DROP COLUMN IF EXISTS progress_percent;
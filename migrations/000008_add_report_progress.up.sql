@@ -0,0 +1,4 @@
+ALTER TABLE reports ADD COLUMN progress_percent INT NOT NULL DEFAULT 0;
+ALTER TABLE reports ADD COLUMN progress_stage VARCHAR;
+ALTER TABLE reports ADD COLUMN rows_processed BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE reports ADD COLUMN progress_updated_at TIMESTAMPTZ;
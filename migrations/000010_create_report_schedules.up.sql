@@ -0,0 +1,17 @@
+CREATE TABLE report_schedules (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	report_type VARCHAR NOT NULL,
+	parameters JSONB NOT NULL DEFAULT '{}'::jsonb,
+	cron_expression VARCHAR NOT NULL,
+	timezone VARCHAR NOT NULL DEFAULT 'UTC', -- IANA time zone the cron expression is evaluated in
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	next_run_at TIMESTAMPTZ NOT NULL,
+	last_run_at TIMESTAMPTZ,
+	last_report_id UUID,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX report_schedules_user_id_idx ON report_schedules (user_id);
+CREATE INDEX report_schedules_due_idx ON report_schedules (next_run_at) WHERE enabled;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reports_archive;
+ALTER TABLE reports DROP COLUMN IF EXISTS output_expired_at;
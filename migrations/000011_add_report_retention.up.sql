@@ -0,0 +1,12 @@
+ALTER TABLE reports ADD COLUMN output_expired_at TIMESTAMPTZ; -- set when the retention sweeper deleted the output
+
+-- rows removed by the retention sweeper when archiving is enabled, the report row is kept as JSON
+-- so the table does not have to follow the schema changes of reports
+CREATE TABLE reports_archive (
+	user_id UUID NOT NULL,
+	id UUID NOT NULL,
+	report_type VARCHAR NOT NULL,
+	report JSONB NOT NULL,
+	archived_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, id)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE idempotency_keys (
+	user_id UUID NOT NULL, -- nil uuid for requests without a user, e.g. signups
+	key VARCHAR(255) NOT NULL,
+	fingerprint VARCHAR NOT NULL, -- sha256 of the method, path and body of the first request
+	status_code INT, -- NULL while the first request is in flight
+	response_headers JSONB NOT NULL DEFAULT '{}'::jsonb,
+	response_body BYTEA NOT NULL DEFAULT ''::bytea,
+	locked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	completed_at TIMESTAMPTZ,
+	PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS reports_output_file_path_idx;
+DROP INDEX IF EXISTS reports_source_report_id_idx;
+DROP INDEX IF EXISTS reports_fingerprint_idx;
+ALTER TABLE reports DROP COLUMN IF EXISTS source_report_id;
+ALTER TABLE reports DROP COLUMN IF EXISTS fingerprint;
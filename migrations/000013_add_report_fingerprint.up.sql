@@ -0,0 +1,6 @@
+ALTER TABLE reports ADD COLUMN fingerprint VARCHAR; -- sha256 of the report type and normalized parameters
+ALTER TABLE reports ADD COLUMN source_report_id UUID; -- identical report whose result this report reuses or waits for
+
+CREATE INDEX reports_fingerprint_idx ON reports (fingerprint, completed_at);
+CREATE INDEX reports_source_report_id_idx ON reports (source_report_id) WHERE source_report_id IS NOT NULL;
+CREATE INDEX reports_output_file_path_idx ON reports (output_file_path) WHERE output_file_path IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+ALTER TABLE users DROP COLUMN IF EXISTS max_priority;
+ALTER TABLE reports DROP COLUMN IF EXISTS priority;
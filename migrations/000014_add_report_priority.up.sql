@@ -0,0 +1,3 @@
+ALTER TABLE reports ADD COLUMN priority VARCHAR NOT NULL DEFAULT 'normal'; -- low, normal or high, the queue lane of the report jobs
+ALTER TABLE users ADD COLUMN max_priority VARCHAR NOT NULL DEFAULT 'normal'; -- highest priority the user may request, set by admins
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+ALTER TABLE reports DROP COLUMN IF EXISTS output_checksum;
+ALTER TABLE reports DROP COLUMN IF EXISTS output_size;
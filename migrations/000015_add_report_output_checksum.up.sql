@@ -0,0 +1,2 @@
+ALTER TABLE reports ADD COLUMN output_size BIGINT; -- size of the output in bytes
+ALTER TABLE reports ADD COLUMN output_checksum VARCHAR(64); -- hex encoded sha256 of the output
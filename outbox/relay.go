@@ -0,0 +1,81 @@
+package outbox
+
+import (
+	"async_api/config"
+	"async_api/queue"
+	"async_api/store"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Number of outbox messages published per transaction
+const relayBatchSize = 100
+
+// Relay publishes the jobs written to the outbox to the report queue. Delivery is at least once,
+// every job carries the dedup key of its outbox message, which not every queue backend acts on,
+// see queue.Publisher. Several relays can run at the same time.
+type Relay struct {
+	config    *config.Config
+	logger    *slog.Logger
+	store     *store.Store
+	publisher queue.Publisher
+}
+
+func NewRelay(config *config.Config, logger *slog.Logger, store *store.Store, publisher queue.Publisher) *Relay {
+	return &Relay{
+		config:    config,
+		logger:    logger,
+		store:     store,
+		publisher: publisher,
+	}
+}
+
+// Start drains the outbox every OutboxRelayInterval until ctx is cancelled
+func (r *Relay) Start(ctx context.Context) error {
+	r.logger.Info("starting outbox relay", "interval", r.config.OutboxRelayInterval)
+	ticker := time.NewTicker(r.config.OutboxRelayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.drain(ctx)
+		}
+	}
+}
+
+// drain publishes batches until the outbox is empty or publishing fails
+func (r *Relay) drain(ctx context.Context) {
+	for {
+		published, err := r.store.Outbox.Drain(ctx, relayBatchSize, func(msg store.OutboxMessage) error {
+			return r.publish(ctx, msg)
+		})
+		if published > 0 {
+			r.logger.Info("published outbox messages", "count", published)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Error("failed to drain outbox", "error", err)
+			}
+			return
+		}
+		if published < relayBatchSize {
+			return
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, msg store.OutboxMessage) error {
+	var job queue.ReportJob
+	if err := json.Unmarshal(msg.Payload, &job); err != nil {
+		return fmt.Errorf("failed to decode outbox message %d: %w", msg.ID, err)
+	}
+	job.DedupKey = msg.DedupKey.String()
+
+	return r.publisher.Publish(ctx, job)
+}
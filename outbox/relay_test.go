@@ -0,0 +1,57 @@
+package outbox_test
+
+import (
+	"async_api/config"
+	"async_api/fixture"
+	"async_api/outbox"
+	"async_api/queue"
+	"async_api/store"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelay(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataStore := store.New(env.DB)
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	report1, err := dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	report2, err := dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{Priority: store.PriorityHigh})
+	require.NoError(t, err)
+
+	reportQueue := queue.NewMemoryQueue()
+	conf := &config.Config{OutboxRelayInterval: 10 * time.Millisecond}
+	relay := outbox.NewRelay(conf, slog.New(slog.NewTextHandler(os.Stdout, nil)), dataStore, reportQueue)
+	go relay.Start(ctx)
+
+	require.Eventually(t, func() bool { return reportQueue.Len() == 2 }, time.Second, 10*time.Millisecond)
+
+	msgs, err := reportQueue.Receive(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, report1.ID, msgs[0].Job.ReportID)
+	require.Equal(t, user.ID, msgs[0].Job.UserID)
+	require.NotEmpty(t, msgs[0].Job.DedupKey)
+	require.Equal(t, store.PriorityNormal, msgs[0].Job.Priority)
+	require.Equal(t, report2.ID, msgs[1].Job.ReportID)
+	require.Equal(t, store.PriorityHigh, msgs[1].Job.Priority)
+	require.NotEqual(t, msgs[0].Job.DedupKey, msgs[1].Job.DedupKey)
+
+	published, err := dataStore.Outbox.Drain(ctx, 10, func(msg store.OutboxMessage) error { return nil })
+	require.NoError(t, err)
+	require.Zero(t, published)
+}
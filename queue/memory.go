@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is an in-process Publisher and Consumer used in tests and local runs
+type MemoryQueue struct {
+	mu       sync.Mutex
+	seq      int
+	pending  []*Message
+	inflight map[string]*Message
+	seen     map[string]struct{}
+	notify   chan struct{}
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		inflight: make(map[string]*Message),
+		seen:     make(map[string]struct{}),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, job ReportJob) error {
+	q.mu.Lock()
+	if job.DedupKey != "" {
+		if _, ok := q.seen[job.DedupKey]; ok {
+			q.mu.Unlock()
+			return nil
+		}
+		q.seen[job.DedupKey] = struct{}{}
+	}
+	q.seq++
+	id := strconv.Itoa(q.seq)
+	q.pending = append(q.pending, &Message{ID: id, Job: job, receiptHandle: id})
+	q.mu.Unlock()
+
+	q.wakeup()
+	return nil
+}
+
+func (q *MemoryQueue) Receive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	for {
+		if msgs := q.take(maxMessages); len(msgs) > 0 {
+			return msgs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *MemoryQueue) TryReceive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	return q.take(maxMessages), nil
+}
+
+// take moves up to maxMessages pending messages in flight
+func (q *MemoryQueue) take(maxMessages int) []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil
+	}
+	n := min(maxMessages, len(q.pending))
+	msgs := q.pending[:n:n]
+	q.pending = q.pending[n:]
+	for _, msg := range msgs {
+		msg.ReceiveCount++
+		q.inflight[msg.receiptHandle] = msg
+	}
+	if len(q.pending) > 0 {
+		q.wakeup()
+	}
+	return msgs
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, msg *Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.inflight[msg.receiptHandle]; !ok {
+		return fmt.Errorf("message %s is not in flight", msg.ID)
+	}
+	delete(q.inflight, msg.receiptHandle)
+	return nil
+}
+
+func (q *MemoryQueue) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.inflight[msg.receiptHandle]; !ok {
+		return fmt.Errorf("message %s is not in flight", msg.ID)
+	}
+	delete(q.inflight, msg.receiptHandle)
+
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		q.pending = append(q.pending, msg)
+		q.mu.Unlock()
+		q.wakeup()
+	})
+	return nil
+}
+
+// ExtendVisibility only checks that the message is in flight, messages of the memory queue
+// stay in flight until they are acked or nacked
+func (q *MemoryQueue) ExtendVisibility(ctx context.Context, msg *Message, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.inflight[msg.receiptHandle]; !ok {
+		return fmt.Errorf("message %s is not in flight", msg.ID)
+	}
+	return nil
+}
+
+// Len returns the number of messages waiting to be received
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *MemoryQueue) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
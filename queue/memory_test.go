@@ -0,0 +1,71 @@
+package queue_test
+
+import (
+	"async_api/queue"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue()
+
+	job1 := queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New()}
+	job2 := queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New()}
+	require.NoError(t, q.Publish(ctx, job1))
+	require.NoError(t, q.Publish(ctx, job2))
+	require.Equal(t, 2, q.Len())
+
+	msgs, err := q.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, job1, msgs[0].Job)
+	require.Equal(t, 1, msgs[0].ReceiveCount)
+
+	msgs2, err := q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs2, 1)
+	require.Equal(t, job2, msgs2[0].Job)
+	require.Equal(t, 0, q.Len())
+
+	require.NoError(t, q.ExtendVisibility(ctx, msgs[0], time.Minute))
+	require.NoError(t, q.Ack(ctx, msgs[0]))
+	require.Error(t, q.Ack(ctx, msgs[0]))
+	require.Error(t, q.ExtendVisibility(ctx, msgs[0], time.Minute))
+
+	// Receive blocks until a job is published or the context is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = q.Receive(timeoutCtx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Publish(ctx, job1)
+	}()
+	msgs, err = q.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, job1, msgs[0].Job)
+
+	require.NoError(t, q.Nack(ctx, msgs[0], 20*time.Millisecond))
+	require.Error(t, q.Ack(ctx, msgs[0]))
+	require.Equal(t, 0, q.Len())
+
+	msgs, err = q.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, job1, msgs[0].Job)
+	require.Equal(t, 2, msgs[0].ReceiveCount)
+	require.NoError(t, q.Ack(ctx, msgs[0]))
+
+	// jobs with a dedup key are published once
+	dedupJob := queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New(), DedupKey: uuid.NewString()}
+	require.NoError(t, q.Publish(ctx, dedupJob))
+	require.NoError(t, q.Publish(ctx, dedupJob))
+	require.Equal(t, 1, q.Len())
+}
@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PostgresQueue is a Publisher and Consumer backed by the jobs table. Jobs are claimed with
+// SELECT ... FOR UPDATE SKIP LOCKED, so any number of consumers can share a queue.
+// Like SQS, a claimed job becomes visible again when it is not acked within the visibility timeout.
+type PostgresQueue struct {
+	db                *sqlx.DB
+	logger            *slog.Logger
+	queue             string
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+}
+
+func NewPostgresQueue(db *sql.DB, logger *slog.Logger, queue string, visibilityTimeout, pollInterval time.Duration) *PostgresQueue {
+	return &PostgresQueue{
+		db:                sqlx.NewDb(db, "postgres"),
+		logger:            logger,
+		queue:             queue,
+		visibilityTimeout: visibilityTimeout,
+		pollInterval:      pollInterval,
+	}
+}
+
+type jobRecord struct {
+	ID           int64           `db:"id"`
+	Payload      json.RawMessage `db:"payload"`
+	ReceiveCount int             `db:"receive_count"`
+}
+
+func (q *PostgresQueue) Publish(ctx context.Context, job ReportJob) error {
+	const stmt = `INSERT INTO jobs (queue, payload, dedup_key) VALUES ($1, $2, NULLIF($3, '')) ON CONFLICT (dedup_key) DO NOTHING;`
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode report job: %w", err)
+	}
+
+	if _, err := q.db.ExecContext(ctx, stmt, q.queue, payload, job.DedupKey); err != nil {
+		return fmt.Errorf("failed to insert job: %w", err)
+	}
+	return nil
+}
+
+// Receive claims up to maxMessages visible jobs. When there are none it waits
+// for the poll interval and returns no messages.
+func (q *PostgresQueue) Receive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	msgs, err := q.TryReceive(ctx, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msgs) == 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(q.pollInterval):
+			return nil, nil
+		}
+	}
+	return msgs, nil
+}
+
+// TryReceive claims up to maxMessages visible jobs without waiting
+func (q *PostgresQueue) TryReceive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	const stmt = `UPDATE jobs SET visible_at = CURRENT_TIMESTAMP + make_interval(secs => $3), receive_count = receive_count + 1
+	WHERE id IN (
+		SELECT id FROM jobs WHERE queue = $1 AND visible_at <= CURRENT_TIMESTAMP
+		ORDER BY visible_at, id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, payload, receive_count;`
+	var records []jobRecord
+	if err := q.db.SelectContext(ctx, &records, stmt, q.queue, maxMessages, q.visibilityTimeout.Seconds()); err != nil {
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+
+	msgs := make([]*Message, 0, len(records))
+	for _, record := range records {
+		var job ReportJob
+		if err := json.Unmarshal(record.Payload, &job); err != nil {
+			// the job would be claimed again forever, so it is dropped and the rest of the batch delivered
+			q.logger.Error("dropping report job that can not be decoded", "error", err, "job_id", record.ID, "payload", string(record.Payload))
+			if err := q.delete(ctx, record.ID, record.ReceiveCount); err != nil {
+				q.logger.Error("failed to drop report job", "error", err)
+			}
+			continue
+		}
+		msgs = append(msgs, &Message{
+			ID:           strconv.FormatInt(record.ID, 10),
+			Job:          job,
+			ReceiveCount: record.ReceiveCount,
+			// a job claimed again after its visibility timeout gets a new receive count,
+			// which invalidates the receipt handle of the earlier claim
+			receiptHandle: fmt.Sprintf("%d:%d", record.ID, record.ReceiveCount),
+		})
+	}
+	return msgs, nil
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, msg *Message) error {
+	id, receiveCount, err := parseReceiptHandle(msg.receiptHandle)
+	if err != nil {
+		return err
+	}
+	return q.delete(ctx, id, receiveCount)
+}
+
+func (q *PostgresQueue) delete(ctx context.Context, id int64, receiveCount int) error {
+	const stmt = `DELETE FROM jobs WHERE id = $1 AND receive_count = $2;`
+	if err := q.execClaimed(ctx, stmt, id, receiveCount); err != nil {
+		return fmt.Errorf("failed to delete job %d: %w", id, err)
+	}
+	return nil
+}
+
+func (q *PostgresQueue) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	const stmt = `UPDATE jobs SET visible_at = CURRENT_TIMESTAMP + make_interval(secs => $3) WHERE id = $1 AND receive_count = $2;`
+	id, receiveCount, err := parseReceiptHandle(msg.receiptHandle)
+	if err != nil {
+		return err
+	}
+
+	if err := q.execClaimed(ctx, stmt, id, receiveCount, delay.Seconds()); err != nil {
+		return fmt.Errorf("failed to change visibility of job %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+func (q *PostgresQueue) ExtendVisibility(ctx context.Context, msg *Message, timeout time.Duration) error {
+	const stmt = `UPDATE jobs SET visible_at = CURRENT_TIMESTAMP + make_interval(secs => $3) WHERE id = $1 AND receive_count = $2;`
+	id, receiveCount, err := parseReceiptHandle(msg.receiptHandle)
+	if err != nil {
+		return err
+	}
+
+	if err := q.execClaimed(ctx, stmt, id, receiveCount, timeout.Seconds()); err != nil {
+		return fmt.Errorf("failed to extend visibility of job %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// execClaimed runs a statement on a job claimed by us, it fails when the job was claimed again since
+func (q *PostgresQueue) execClaimed(ctx context.Context, stmt string, args ...any) error {
+	result, err := q.db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("receipt handle is no longer valid")
+	}
+	return nil
+}
+
+func parseReceiptHandle(receiptHandle string) (int64, int, error) {
+	var id int64
+	var receiveCount int
+	if _, err := fmt.Sscanf(receiptHandle, "%d:%d", &id, &receiveCount); err != nil {
+		return 0, 0, fmt.Errorf("invalid receipt handle %q: %w", receiptHandle, err)
+	}
+	return id, receiveCount, nil
+}
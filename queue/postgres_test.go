@@ -0,0 +1,87 @@
+package queue_test
+
+import (
+	"async_api/fixture"
+	"async_api/queue"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresQueue(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	q := queue.NewPostgresQueue(env.DB, slog.Default(), "test", time.Minute, time.Second)
+	other := queue.NewPostgresQueue(env.DB, slog.Default(), "other", time.Minute, time.Second)
+
+	job1 := queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New()}
+	job2 := queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New()}
+	require.NoError(t, q.Publish(ctx, job1))
+	require.NoError(t, q.Publish(ctx, job2))
+
+	msgs, err := other.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+
+	msgs, err = q.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, job1, msgs[0].Job)
+	require.Equal(t, 1, msgs[0].ReceiveCount)
+
+	// a claimed job is hidden from other consumers
+	msgs2, err := q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs2, 1)
+	require.Equal(t, job2, msgs2[0].Job)
+
+	msgs3, err := q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, msgs3)
+
+	require.NoError(t, q.Ack(ctx, msgs[0]))
+	require.Error(t, q.Ack(ctx, msgs[0]))
+
+	require.NoError(t, q.Nack(ctx, msgs2[0], 0))
+	msgs3, err = q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs3, 1)
+	require.Equal(t, job2, msgs3[0].Job)
+	require.Equal(t, 2, msgs3[0].ReceiveCount)
+
+	// the receipt handle of the first claim is stale now
+	require.Error(t, q.Ack(ctx, msgs2[0]))
+	require.Error(t, q.ExtendVisibility(ctx, msgs2[0], time.Minute))
+	require.NoError(t, q.ExtendVisibility(ctx, msgs3[0], time.Minute))
+	require.NoError(t, q.Ack(ctx, msgs3[0]))
+
+	dedupJob := queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New(), DedupKey: uuid.NewString()}
+	require.NoError(t, q.Publish(ctx, dedupJob))
+	require.NoError(t, q.Publish(ctx, dedupJob))
+	msgs, err = q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, dedupJob, msgs[0].Job)
+	require.NoError(t, q.Ack(ctx, msgs[0]))
+
+	// a job that can not be decoded is dropped, the rest of the batch is delivered
+	_, err = env.DB.ExecContext(ctx, `INSERT INTO jobs (queue, payload) VALUES ('test', '{"user_id": "not a uuid"}');`)
+	require.NoError(t, err)
+	require.NoError(t, q.Publish(ctx, job1))
+	msgs, err = q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, job1, msgs[0].Job)
+	var count int
+	require.NoError(t, env.DB.QueryRowContext(ctx, `SELECT count(*) FROM jobs WHERE queue = 'test'`).Scan(&count))
+	require.Equal(t, 1, count)
+}
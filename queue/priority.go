@@ -0,0 +1,157 @@
+package queue
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PriorityQueue routes jobs to one lane per priority and consumes the lanes with smooth weighted round robin.
+// Every Receive starts with the lane that is due according to the weights and falls back to the other lanes
+// when it is empty, so a busy lane does not leave workers idle and a lane with a small weight still gets its
+// share of the receives when all lanes have jobs. When all lanes are empty it long polls the due lane, the lanes
+// bound the wait by their poll interval, so jobs published to another lane meanwhile wait at most that long.
+type PriorityQueue struct {
+	lanes       map[string]Lane
+	weights     map[string]int
+	defaultLane string
+	// fallback is the order in which the lanes are tried after the due one, by descending weight
+	fallback []string
+
+	mu      sync.Mutex
+	current map[string]int
+}
+
+// NewPriorityQueue creates a queue over lanes, jobs without a priority are published to defaultLane.
+// Every lane needs a positive weight.
+func NewPriorityQueue(lanes map[string]Lane, weights map[string]int, defaultLane string) (*PriorityQueue, error) {
+	if _, ok := lanes[defaultLane]; !ok {
+		return nil, fmt.Errorf("default lane %q is not a lane", defaultLane)
+	}
+	fallback := make([]string, 0, len(lanes))
+	for name := range lanes {
+		if weights[name] <= 0 {
+			return nil, fmt.Errorf("lane %q needs a positive weight", name)
+		}
+		fallback = append(fallback, name)
+	}
+	slices.SortFunc(fallback, func(a, b string) int {
+		return cmp.Or(cmp.Compare(weights[b], weights[a]), cmp.Compare(a, b))
+	})
+
+	return &PriorityQueue{
+		lanes:       lanes,
+		weights:     weights,
+		defaultLane: defaultLane,
+		fallback:    fallback,
+		current:     make(map[string]int, len(lanes)),
+	}, nil
+}
+
+func (q *PriorityQueue) Publish(ctx context.Context, job ReportJob) error {
+	name := job.Priority
+	if name == "" {
+		name = q.defaultLane
+	}
+	lane, ok := q.lanes[name]
+	if !ok {
+		return fmt.Errorf("no lane for priority %q", job.Priority)
+	}
+	return lane.Publish(ctx, job)
+}
+
+// Receive takes up to maxMessages jobs from the first lane in the order of next that has any.
+// When all lanes are empty it waits on the due lane like its Receive does.
+func (q *PriorityQueue) Receive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	order := q.next()
+	for _, name := range order {
+		msgs, err := q.lanes[name].TryReceive(ctx, maxMessages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive from lane %s: %w", name, err)
+		}
+		if len(msgs) > 0 {
+			return q.received(name, msgs), nil
+		}
+	}
+
+	due := order[0]
+	msgs, err := q.lanes[due].Receive(ctx, maxMessages)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to receive from lane %s: %w", due, err)
+	}
+	return q.received(due, msgs), nil
+}
+
+// received marks msgs as received from the lane, so they are acked there
+func (q *PriorityQueue) received(lane string, msgs []*Message) []*Message {
+	for _, msg := range msgs {
+		msg.lane = lane
+	}
+	return msgs
+}
+
+func (q *PriorityQueue) Ack(ctx context.Context, msg *Message) error {
+	lane, err := q.laneOf(msg)
+	if err != nil {
+		return err
+	}
+	return lane.Ack(ctx, msg)
+}
+
+func (q *PriorityQueue) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	lane, err := q.laneOf(msg)
+	if err != nil {
+		return err
+	}
+	return lane.Nack(ctx, msg, delay)
+}
+
+func (q *PriorityQueue) ExtendVisibility(ctx context.Context, msg *Message, timeout time.Duration) error {
+	lane, err := q.laneOf(msg)
+	if err != nil {
+		return err
+	}
+	return lane.ExtendVisibility(ctx, msg, timeout)
+}
+
+func (q *PriorityQueue) laneOf(msg *Message) (Lane, error) {
+	lane, ok := q.lanes[msg.lane]
+	if !ok {
+		return nil, fmt.Errorf("message %s was not received from a lane", msg.ID)
+	}
+	return lane, nil
+}
+
+// next returns the lane due according to the weights followed by the other lanes in fallback order.
+// Every call adds the weight of each lane to its credit, the lane with the most credit is due and
+// pays the sum of the weights, so over sum(weights) calls each lane is due weight times.
+func (q *PriorityQueue) next() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	total := 0
+	due := ""
+	for _, name := range q.fallback {
+		q.current[name] += q.weights[name]
+		total += q.weights[name]
+		if due == "" || q.current[name] > q.current[due] {
+			due = name
+		}
+	}
+	q.current[due] -= total
+
+	order := make([]string, 0, len(q.fallback))
+	order = append(order, due)
+	for _, name := range q.fallback {
+		if name != due {
+			order = append(order, name)
+		}
+	}
+	return order
+}
@@ -0,0 +1,100 @@
+package queue_test
+
+import (
+	"async_api/queue"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	ctx := context.Background()
+	high, normal, low := queue.NewMemoryQueue(), queue.NewMemoryQueue(), queue.NewMemoryQueue()
+	q, err := queue.NewPriorityQueue(map[string]queue.Lane{"high": high, "normal": normal, "low": low},
+		map[string]int{"high": 6, "normal": 3, "low": 1}, "normal")
+	require.NoError(t, err)
+
+	_, err = queue.NewPriorityQueue(map[string]queue.Lane{"high": high}, map[string]int{}, "high")
+	require.Error(t, err)
+
+	// jobs are routed by priority, jobs without one go to the default lane
+	require.NoError(t, q.Publish(ctx, queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New()}))
+	require.Equal(t, 1, normal.Len())
+	require.Error(t, q.Publish(ctx, queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New(), Priority: "urgent"}))
+
+	msgs, err := q.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.NoError(t, q.Ack(ctx, msgs[0]))
+
+	for range 20 {
+		for _, priority := range []string{"high", "normal", "low"} {
+			require.NoError(t, q.Publish(ctx, queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New(), Priority: priority}))
+		}
+	}
+
+	// the lanes are consumed in proportion to their weights, low priority jobs are not starved
+	received := map[string]int{}
+	for range 20 {
+		msgs, err := q.Receive(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		received[msgs[0].Job.Priority]++
+		require.NoError(t, q.Ack(ctx, msgs[0]))
+	}
+	require.Equal(t, map[string]int{"high": 12, "normal": 6, "low": 2}, received)
+
+	// empty lanes fall back to the others
+	for high.Len() > 0 || normal.Len() > 0 {
+		msgs, err := q.Receive(ctx, 10)
+		require.NoError(t, err)
+		require.NotEqual(t, "low", msgs[0].Job.Priority)
+		for _, msg := range msgs {
+			require.NoError(t, q.Ack(ctx, msg))
+		}
+	}
+	msgs, err = q.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "low", msgs[0].Job.Priority)
+
+	// nacked messages go back to their lane
+	require.NoError(t, q.Nack(ctx, msgs[0], 0))
+	require.Eventually(t, func() bool { return low.Len() == 18 }, time.Second, 5*time.Millisecond)
+	require.Error(t, q.Ack(ctx, &queue.Message{ID: "unknown"}))
+
+	// Receive waits when all lanes are empty
+	for low.Len() > 0 {
+		msgs, err := q.Receive(ctx, 10)
+		require.NoError(t, err)
+		for _, msg := range msgs {
+			require.NoError(t, q.Ack(ctx, msg))
+		}
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = q.Receive(timeoutCtx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// it long polls the due lane, a job published meanwhile is received right away
+	done := make(chan []*queue.Message)
+	go func() {
+		msgs, err := q.Receive(ctx, 10)
+		assert.NoError(t, err)
+		done <- msgs
+	}()
+	time.Sleep(20 * time.Millisecond)
+	for _, priority := range []string{"high", "normal", "low"} {
+		require.NoError(t, q.Publish(ctx, queue.ReportJob{UserID: uuid.New(), ReportID: uuid.New(), Priority: priority}))
+	}
+	select {
+	case msgs := <-done:
+		require.Len(t, msgs, 1)
+		require.NoError(t, q.Ack(ctx, msgs[0]))
+	case <-time.After(time.Second):
+		t.Fatal("Receive did not return the published job")
+	}
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"async_api/config"
+	"async_api/store"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BackendSQS      = "sqs"
+	BackendPostgres = "postgres"
+)
+
+// Name of the report jobs queue in the jobs table, the lanes of the other priorities get a suffix
+const postgresReportsQueue = "reports"
+
+// ReportJob is the message body asking a worker to generate a report
+type ReportJob struct {
+	UserID   uuid.UUID `json:"user_id"`
+	ReportID uuid.UUID `json:"report_id"`
+	// Priority selects the lane of the job, the default lane if empty
+	Priority string `json:"priority,omitempty"`
+	// DedupKey identifies the job across redeliveries of the same outbox message
+	DedupKey string `json:"dedup_key,omitempty"`
+}
+
+// Message is a received job together with the data needed to acknowledge it
+type Message struct {
+	ID           string
+	Job          ReportJob
+	ReceiveCount int
+
+	receiptHandle string
+	// lane the message was received from by a PriorityQueue
+	lane string
+}
+
+type Publisher interface {
+	// Publish enqueues a new report job. Deduplication on DedupKey is best effort per backend:
+	// the memory and postgres queues drop a job whose DedupKey was published before, the SQS
+	// queue delivers it again. Consumers have to tolerate duplicates, the worker does since only
+	// one receive of a job can start its report.
+	Publish(ctx context.Context, job ReportJob) error
+}
+
+type Consumer interface {
+	// Receive waits for up to maxMessages jobs. It may return no messages
+	// when nothing was available before the backend's wait time ran out.
+	Receive(ctx context.Context, maxMessages int) ([]*Message, error)
+	// Ack removes a processed message from the queue
+	Ack(ctx context.Context, msg *Message) error
+	// Nack returns a message to the queue, it is received again once delay has passed
+	Nack(ctx context.Context, msg *Message, delay time.Duration) error
+	// ExtendVisibility keeps a message in process hidden from other consumers for timeout from now
+	ExtendVisibility(ctx context.Context, msg *Message, timeout time.Duration) error
+}
+
+type Queue interface {
+	Publisher
+	Consumer
+}
+
+// Lane is a Queue that can be checked for jobs without waiting, a PriorityQueue consumes several of them
+type Lane interface {
+	Queue
+	// TryReceive returns up to maxMessages jobs that are available right away
+	TryReceive(ctx context.Context, maxMessages int) ([]*Message, error)
+}
+
+// NewFromConfig creates the Queue selected by conf.QueueBackend, with one lane per report priority
+// consumed according to conf.QueuePriorityWeights
+func NewFromConfig(ctx context.Context, conf *config.Config, logger *slog.Logger, db *sql.DB) (Queue, error) {
+	if conf.QueueVisibilityTimeout < time.Second {
+		return nil, fmt.Errorf("queue visibility timeout %s is shorter than 1s", conf.QueueVisibilityTimeout)
+	}
+	lanes := make(map[string]Lane, len(store.Priorities))
+	switch conf.QueueBackend {
+	case BackendSQS:
+		client, err := NewSQSClient(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		for _, priority := range store.Priorities {
+			lane, err := NewSQSQueue(ctx, client, logger, laneName(conf.SQSQueue, priority), conf.QueueVisibilityTimeout, conf.QueuePollInterval)
+			if err != nil {
+				return nil, err
+			}
+			lanes[priority] = lane
+		}
+	case BackendPostgres:
+		for _, priority := range store.Priorities {
+			lanes[priority] = NewPostgresQueue(db, logger, laneName(postgresReportsQueue, priority), conf.QueueVisibilityTimeout, conf.QueuePollInterval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", conf.QueueBackend)
+	}
+
+	return NewPriorityQueue(lanes, conf.QueuePriorityWeights, store.PriorityNormal)
+}
+
+// laneName is the name of the queue of a priority, the normal lane keeps the name of the queue
+// so jobs published before the lanes existed are still consumed
+func laneName(queue, priority string) string {
+	if priority == store.PriorityNormal {
+		return queue
+	}
+	return queue + "-" + priority
+}
@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"async_api/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maximum long polling wait time allowed by SQS
+	sqsMaxWaitTime          = 20 * time.Second
+	sqsMaxVisibilityTimeout = 12 * time.Hour
+)
+
+func NewSQSClient(ctx context.Context, conf *config.Config) (*sqs.Client, error) {
+	awsConf, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+
+	return sqs.NewFromConfig(awsConf, func(o *sqs.Options) {
+		if conf.SQSLocalstackEndpoint != "" {
+			o.BaseEndpoint = aws.String(conf.SQSLocalstackEndpoint)
+		}
+	}), nil
+}
+
+// SQSQueue is a Publisher and Consumer backed by an SQS (or SQS-compatible) queue
+type SQSQueue struct {
+	client            *sqs.Client
+	logger            *slog.Logger
+	queueUrl          string
+	visibilityTimeout time.Duration
+	waitTime          time.Duration
+}
+
+// NewSQSQueue creates a queue whose Receive long polls for waitTime, at most 20s. Received messages stay
+// hidden for visibilityTimeout, whatever the queue is configured with, so workers know when to extend it.
+func NewSQSQueue(ctx context.Context, client *sqs.Client, logger *slog.Logger, queueName string, visibilityTimeout, waitTime time.Duration) (*SQSQueue, error) {
+	if visibilityTimeout < time.Second || visibilityTimeout > sqsMaxVisibilityTimeout {
+		return nil, fmt.Errorf("sqs visibility timeout %s is not between 1s and %s", visibilityTimeout, sqsMaxVisibilityTimeout)
+	}
+	out, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(queueName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url of queue %s: %w", queueName, err)
+	}
+
+	return &SQSQueue{
+		client:            client,
+		logger:            logger,
+		queueUrl:          *out.QueueUrl,
+		visibilityTimeout: visibilityTimeout,
+		waitTime:          min(waitTime, sqsMaxWaitTime),
+	}, nil
+}
+
+// Publish sends the job to the queue. The queue is a standard queue, so a job published twice
+// with the same DedupKey is delivered twice.
+func (q *SQSQueue) Publish(ctx context.Context, job ReportJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode report job: %w", err)
+	}
+
+	if _, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueUrl),
+		MessageBody: aws.String(string(body)),
+	}); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Receive long polls for up to maxMessages messages
+func (q *SQSQueue) Receive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	return q.receive(ctx, maxMessages, int32(q.waitTime/time.Second))
+}
+
+// TryReceive short polls for up to maxMessages messages, it can miss messages that are available
+func (q *SQSQueue) TryReceive(ctx context.Context, maxMessages int) ([]*Message, error) {
+	return q.receive(ctx, maxMessages, 0)
+}
+
+func (q *SQSQueue) receive(ctx context.Context, maxMessages int, waitTimeSeconds int32) ([]*Message, error) {
+	out, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.queueUrl),
+		MaxNumberOfMessages: int32(min(maxMessages, 10)),
+		VisibilityTimeout:   int32(q.visibilityTimeout / time.Second),
+		WaitTimeSeconds:     waitTimeSeconds,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+			types.MessageSystemAttributeNameApproximateReceiveCount,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive messages: %w", err)
+	}
+
+	msgs := make([]*Message, 0, len(out.Messages))
+	for _, m := range out.Messages {
+		var job ReportJob
+		if err := json.Unmarshal([]byte(aws.ToString(m.Body)), &job); err != nil {
+			// the message would be received again forever and hold up the rest of the queue, so it is dropped
+			q.logger.Error("dropping report job that can not be decoded", "error", err, "message_id", aws.ToString(m.MessageId), "body", aws.ToString(m.Body))
+			if err := q.delete(ctx, aws.ToString(m.MessageId), aws.ToString(m.ReceiptHandle)); err != nil {
+				q.logger.Error("failed to drop report job", "error", err)
+			}
+			continue
+		}
+		receiveCount, _ := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		msgs = append(msgs, &Message{
+			ID:            aws.ToString(m.MessageId),
+			Job:           job,
+			ReceiveCount:  receiveCount,
+			receiptHandle: aws.ToString(m.ReceiptHandle),
+		})
+	}
+	return msgs, nil
+}
+
+func (q *SQSQueue) Ack(ctx context.Context, msg *Message) error {
+	return q.delete(ctx, msg.ID, msg.receiptHandle)
+}
+
+func (q *SQSQueue) delete(ctx context.Context, id, receiptHandle string) error {
+	if _, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueUrl),
+		ReceiptHandle: aws.String(receiptHandle),
+	}); err != nil {
+		return fmt.Errorf("failed to delete message %s: %w", id, err)
+	}
+	return nil
+}
+
+func (q *SQSQueue) Nack(ctx context.Context, msg *Message, delay time.Duration) error {
+	return q.changeVisibility(ctx, msg, delay)
+}
+
+func (q *SQSQueue) ExtendVisibility(ctx context.Context, msg *Message, timeout time.Duration) error {
+	return q.changeVisibility(ctx, msg, timeout)
+}
+
+func (q *SQSQueue) changeVisibility(ctx context.Context, msg *Message, timeout time.Duration) error {
+	if _, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.queueUrl),
+		ReceiptHandle:     aws.String(msg.receiptHandle),
+		VisibilityTimeout: int32(min(timeout, sqsMaxVisibilityTimeout) / time.Second),
+	}); err != nil {
+		return fmt.Errorf("failed to change visibility of message %s: %w", msg.ID, err)
+	}
+	return nil
+}
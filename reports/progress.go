@@ -0,0 +1,17 @@
+package reports
+
+// Progress is how far a generator got with a report
+type Progress struct {
+	// Percent is the estimated completion between 0 and 100
+	Percent int
+	// Stage names what the generator is doing, e.g. "querying" or "writing"
+	Stage         string
+	RowsProcessed int64
+}
+
+// ProgressFunc receives the progress of a generator. It is cheap to call, the caller
+// throttles the updates, so generators may report progress as often as they like.
+type ProgressFunc func(Progress)
+
+// NoProgress discards progress updates
+func NoProgress(Progress) {}
@@ -0,0 +1,135 @@
+package reports
+
+import (
+	"async_api/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// ParamSpec describes one parameter accepted by a report type
+type ParamSpec struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// Params are the typed parameters of a report type. They satisfy the Validator interface
+// of the api server, so they are checked with the create request.
+type Params interface {
+	Validate() error
+}
+
+// ReportGenerator produces the output of one report type
+type ReportGenerator interface {
+	// Name is the report_type handled by the generator
+	Name() string
+	// Schema describes the parameters of the report type
+	Schema() []ParamSpec
+	// NewParams returns a pointer to the zero value of the report type parameters
+	NewParams() Params
+	// Extension is the file extension of the generated output, e.g. ".csv"
+	Extension() string
+	// Generate writes the output to w as it is produced, so it does not have to fit in memory. It receives
+	// the params returned by NewParams, decoded from the report row, and reports how far it got through progress.
+	// Writes fail once the upload failed or ctx is done.
+	Generate(ctx context.Context, w io.Writer, report *store.Report, params Params, progress ProgressFunc) error
+}
+
+// SharedResultGenerator is implemented by generators whose output only depends on the report type and
+// parameters, not on the user. Identical reports of such a type share one result.
+type SharedResultGenerator interface {
+	SharesResults() bool
+}
+
+// SharesResults reports whether identical reports of the generator may share one result
+func SharesResults(generator ReportGenerator) bool {
+	g, ok := generator.(SharedResultGenerator)
+	return ok && g.SharesResults()
+}
+
+// DecodeParams decodes and validates the parameters of a report type.
+// Empty data decodes as an empty JSON object.
+func DecodeParams(generator ReportGenerator, data json.RawMessage) (Params, error) {
+	params := generator.NewParams()
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = json.RawMessage(`{}`)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(params); err != nil {
+		return nil, fmt.Errorf("invalid %s parameters: %w", generator.Name(), err)
+	}
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s parameters: %w", generator.Name(), err)
+	}
+	return params, nil
+}
+
+// Registry maps report types to their generators
+type Registry struct {
+	mu         sync.RWMutex
+	generators map[string]ReportGenerator
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		generators: make(map[string]ReportGenerator),
+	}
+}
+
+// DefaultRegistry returns a registry with the built-in report types
+func DefaultRegistry() *Registry {
+	registry := NewRegistry()
+	registry.MustRegister(SampleGenerator{})
+	return registry
+}
+
+// Register adds a generator, it fails if its report type is already registered
+func (r *Registry) Register(generator ReportGenerator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := generator.Name()
+	if name == "" {
+		return fmt.Errorf("report generator name is required")
+	}
+	if _, ok := r.generators[name]; ok {
+		return fmt.Errorf("report type %q is already registered", name)
+	}
+	r.generators[name] = generator
+	return nil
+}
+
+func (r *Registry) MustRegister(generator ReportGenerator) {
+	if err := r.Register(generator); err != nil {
+		panic(err)
+	}
+}
+
+func (r *Registry) Lookup(name string) (ReportGenerator, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	generator, ok := r.generators[name]
+	return generator, ok
+}
+
+// Names returns the registered report types in alphabetical order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.generators))
+	for name := range r.generators {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,45 @@
+package reports_test
+
+import (
+	"async_api/reports"
+	"async_api/store"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testGenerator struct {
+	name string
+}
+
+type testParams struct{}
+
+func (p *testParams) Validate() error { return nil }
+
+func (g testGenerator) Name() string                { return g.name }
+func (g testGenerator) Schema() []reports.ParamSpec { return nil }
+func (g testGenerator) NewParams() reports.Params   { return &testParams{} }
+func (g testGenerator) Extension() string           { return ".txt" }
+func (g testGenerator) Generate(ctx context.Context, w io.Writer, report *store.Report, params reports.Params, progress reports.ProgressFunc) error {
+	_, err := io.WriteString(w, g.name)
+	return err
+}
+
+func TestRegistry(t *testing.T) {
+	registry := reports.DefaultRegistry()
+	require.Equal(t, []string{"sample"}, registry.Names())
+
+	require.NoError(t, registry.Register(testGenerator{name: "audit"}))
+	require.Error(t, registry.Register(testGenerator{name: "audit"}))
+	require.Error(t, registry.Register(testGenerator{}))
+	require.Equal(t, []string{"audit", "sample"}, registry.Names())
+
+	generator, ok := registry.Lookup("audit")
+	require.True(t, ok)
+	require.Equal(t, "audit", generator.Name())
+
+	_, ok = registry.Lookup("unknown")
+	require.False(t, ok)
+}
@@ -0,0 +1,86 @@
+package reports
+
+import (
+	"async_api/store"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	sampleDefaultRows = 100
+	sampleMaxRows     = 100_000
+	// number of rows between progress updates
+	sampleProgressEvery = 1000
+)
+
+type SampleParams struct {
+	Rows int `json:"rows"`
+}
+
+func (p *SampleParams) Validate() error {
+	if p.Rows == 0 {
+		p.Rows = sampleDefaultRows
+	}
+	if p.Rows < 0 || p.Rows > sampleMaxRows {
+		return errors.New("rows must be between 1 and " + strconv.Itoa(sampleMaxRows))
+	}
+	return nil
+}
+
+// SampleGenerator produces a CSV document with the requested number of rows, it is used to exercise the pipeline
+type SampleGenerator struct{}
+
+func (SampleGenerator) Name() string {
+	return "sample"
+}
+
+func (SampleGenerator) Schema() []ParamSpec {
+	return []ParamSpec{
+		{Name: "rows", Type: "integer", Description: fmt.Sprintf("number of rows, %d by default, at most %d", sampleDefaultRows, sampleMaxRows)},
+	}
+}
+
+func (SampleGenerator) NewParams() Params {
+	return &SampleParams{}
+}
+
+func (SampleGenerator) Extension() string {
+	return ".csv"
+}
+
+// SharesResults is true, the rows only depend on the parameters. Reports sharing a result
+// keep the report_id of the report that generated it.
+func (SampleGenerator) SharesResults() bool {
+	return true
+}
+
+func (SampleGenerator) Generate(ctx context.Context, out io.Writer, report *store.Report, params Params, progress ProgressFunc) error {
+	p := params.(*SampleParams)
+	progress(Progress{Stage: "generating"})
+
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"row", "report_id", "value"}); err != nil {
+		return fmt.Errorf("failed to write csv header: %w", err)
+	}
+	for i := 1; i <= p.Rows; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.Write([]string{strconv.Itoa(i), report.ID.String(), strconv.Itoa(i * i)}); err != nil {
+			return fmt.Errorf("failed to write csv row: %w", err)
+		}
+		if i%sampleProgressEvery == 0 || i == p.Rows {
+			progress(Progress{Percent: i * 100 / p.Rows, Stage: "generating", RowsProcessed: int64(i)})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to flush csv: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package reports_test
+
+import (
+	"async_api/reports"
+	"async_api/store"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleGenerator(t *testing.T) {
+	generator := reports.SampleGenerator{}
+	require.Equal(t, "sample", generator.Name())
+	require.Equal(t, ".csv", generator.Extension())
+	require.True(t, reports.SharesResults(generator))
+
+	report := &store.Report{UserID: uuid.New(), ID: uuid.New(), ReportType: "sample"}
+	params, err := reports.DecodeParams(generator, nil)
+	require.NoError(t, err)
+	require.Equal(t, &reports.SampleParams{Rows: 100}, params)
+
+	var (
+		updates []reports.Progress
+		data    bytes.Buffer
+	)
+	err = generator.Generate(context.Background(), &data, report, params, func(p reports.Progress) {
+		updates = append(updates, p)
+	})
+	require.NoError(t, err)
+	require.Equal(t, reports.Progress{Stage: "generating"}, updates[0])
+	require.Equal(t, reports.Progress{Percent: 100, Stage: "generating", RowsProcessed: 100}, updates[len(updates)-1])
+
+	records, err := csv.NewReader(&data).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 101)
+	require.Equal(t, []string{"row", "report_id", "value"}, records[0])
+	require.Equal(t, []string{"3", report.ID.String(), "9"}, records[3])
+
+	params, err = reports.DecodeParams(generator, json.RawMessage(`{"rows": 5}`))
+	require.NoError(t, err)
+	data.Reset()
+	err = generator.Generate(context.Background(), &data, report, params, reports.NoProgress)
+	require.NoError(t, err)
+	records, err = csv.NewReader(&data).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 6)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = generator.Generate(ctx, io.Discard, report, params, reports.NoProgress)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSampleParams(t *testing.T) {
+	generator := reports.SampleGenerator{}
+
+	_, err := reports.DecodeParams(generator, json.RawMessage(`{"rows": -1}`))
+	require.Error(t, err)
+	_, err = reports.DecodeParams(generator, json.RawMessage(`{"rows": 100001}`))
+	require.Error(t, err)
+	_, err = reports.DecodeParams(generator, json.RawMessage(`{"rows": "ten"}`))
+	require.Error(t, err)
+	_, err = reports.DecodeParams(generator, json.RawMessage(`{"columns": 3}`))
+	require.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package retention
+
+import (
+	"async_api/blobstore"
+	"async_api/config"
+	"async_api/store"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Number of reports handled per query
+const sweepBatchSize = 500
+
+// Sweeper deletes the outputs of completed reports once they are older than the output retention of their
+// report type, and then the finished reports themselves once they are older than the row retention.
+// It also deletes the idempotency keys older than IdempotencyKeyTTL.
+type Sweeper struct {
+	config    *config.Config
+	logger    *slog.Logger
+	store     *store.Store
+	blobStore blobstore.Store
+}
+
+func NewSweeper(config *config.Config, logger *slog.Logger, store *store.Store, blobStore blobstore.Store) *Sweeper {
+	return &Sweeper{
+		config:    config,
+		logger:    logger,
+		store:     store,
+		blobStore: blobStore,
+	}
+}
+
+// outputPolicy is how long the outputs of completed reports are kept
+func (s *Sweeper) outputPolicy() store.RetentionPolicy {
+	return store.RetentionPolicy{Default: s.config.RetentionOutputTTL, ByReportType: s.config.RetentionOutputTTLs}
+}
+
+// rowPolicy is how long finished reports are kept
+func (s *Sweeper) rowPolicy() store.RetentionPolicy {
+	return store.RetentionPolicy{Default: s.config.RetentionRowTTL, ByReportType: s.config.RetentionRowTTLs}
+}
+
+// Summary is the outcome of one sweep
+type Summary struct {
+	OutputsDeleted         int
+	OutputErrors           int
+	RowsRemoved            int
+	IdempotencyKeysDeleted int
+}
+
+// Start sweeps every RetentionInterval until ctx is cancelled
+func (s *Sweeper) Start(ctx context.Context) error {
+	s.logger.Info("starting retention sweeper", "interval", s.config.RetentionInterval, "archive", s.config.RetentionArchive)
+	ticker := time.NewTicker(s.config.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			start := time.Now()
+			summary, err := s.sweep(ctx)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("retention sweep failed", "error", err)
+			}
+			s.logger.Info("retention sweep finished",
+				"outputs_deleted", summary.OutputsDeleted,
+				"output_errors", summary.OutputErrors,
+				"rows_removed", summary.RowsRemoved,
+				"idempotency_keys_deleted", summary.IdempotencyKeysDeleted,
+				"archive", s.config.RetentionArchive,
+				"duration", time.Since(start),
+			)
+		}
+	}
+}
+
+// sweep deletes the expired outputs first, so the rows expiring in the same run are removed as well
+func (s *Sweeper) sweep(ctx context.Context) (Summary, error) {
+	var summary Summary
+	if err := s.sweepOutputs(ctx, &summary); err != nil {
+		return summary, err
+	}
+	if err := s.sweepRows(ctx, &summary); err != nil {
+		return summary, err
+	}
+	if err := s.sweepIdempotencyKeys(ctx, &summary); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+func (s *Sweeper) sweepOutputs(ctx context.Context, summary *Summary) error {
+	for {
+		reports, err := s.store.Reports.ExpiredOutputs(ctx, s.outputPolicy(), sweepBatchSize)
+		if err != nil {
+			return err
+		}
+
+		cleared := 0
+		for _, report := range reports {
+			logger := s.logger.With("report_id", report.ID, "user_id", report.UserID, "output_file_path", *report.OutputFilePath)
+			// reports created from the cache share the output, it is deleted with the last of them
+			shared, err := s.store.Reports.OutputShared(ctx, report.UserID, report.ID, *report.OutputFilePath)
+			if err != nil {
+				logger.Error("failed to check whether expired report output is shared", "error", err)
+				summary.OutputErrors++
+				continue
+			}
+			// the output is forgotten only once it is gone, a failed delete is retried on the next run
+			if !shared {
+				if err := s.blobStore.Delete(ctx, *report.OutputFilePath); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+					logger.Error("failed to delete expired report output", "error", err)
+					summary.OutputErrors++
+					continue
+				}
+			}
+			if _, err := s.store.Reports.ClearOutput(ctx, report.UserID, report.ID); err != nil {
+				logger.Error("failed to clear expired report output", "error", err)
+				summary.OutputErrors++
+				continue
+			}
+			cleared++
+		}
+		summary.OutputsDeleted += cleared
+
+		// stop on a batch without progress, its reports would be returned again
+		if len(reports) < sweepBatchSize || cleared == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Sweeper) sweepRows(ctx context.Context, summary *Summary) error {
+	for {
+		removed, err := s.store.Reports.DeleteExpired(ctx, s.rowPolicy(), sweepBatchSize, s.config.RetentionArchive)
+		if err != nil {
+			return err
+		}
+		summary.RowsRemoved += removed
+		if removed < sweepBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *Sweeper) sweepIdempotencyKeys(ctx context.Context, summary *Summary) error {
+	for {
+		deleted, err := s.store.Idempotency.DeleteExpired(ctx, s.config.IdempotencyKeyTTL, sweepBatchSize)
+		if err != nil {
+			return err
+		}
+		summary.IdempotencyKeysDeleted += deleted
+		if deleted < sweepBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package retention
+
+import (
+	"async_api/blobstore"
+	"async_api/config"
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweeper(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+	blobStore, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost", []byte("secret"))
+	require.NoError(t, err)
+
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	complete := func(reportType string, age time.Duration) *store.Report {
+		report, err := dataStore.Reports.Create(ctx, user.ID, reportType, nil, store.CreateOptions{})
+		require.NoError(t, err)
+		_, err = dataStore.Reports.MarkStarted(ctx, user.ID, report.ID)
+		require.NoError(t, err)
+		key := "reports/" + report.ID.String() + ".csv"
+		require.NoError(t, blobStore.Put(ctx, key, strings.NewReader("row")))
+		_, err = dataStore.Reports.MarkCompleted(ctx, user.ID, report.ID, 1, store.Output{FilePath: key}, "http://download", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		_, err = env.DB.ExecContext(ctx, `UPDATE reports SET completed_at = $3 WHERE user_id = $1 AND id = $2`, user.ID, report.ID, time.Now().Add(-age))
+		require.NoError(t, err)
+		report, err = dataStore.Reports.ByPrimaryKey(ctx, user.ID, report.ID)
+		require.NoError(t, err)
+		return report
+	}
+	expired := complete("sample", 3*time.Hour)
+	kept := complete("audit", 3*time.Hour)
+	recent := complete("sample", time.Minute)
+	outputOnly := complete("sample", 90*time.Minute)
+	// a report created from the cache keeps the output it shares alive
+	sharing := complete("sample", time.Minute)
+	_, err = env.DB.ExecContext(ctx, `UPDATE reports SET output_file_path = $2 WHERE id = $1`, sharing.ID, *outputOnly.OutputFilePath)
+	require.NoError(t, err)
+
+	conf := &config.Config{
+		RetentionOutputTTL:  time.Hour,
+		RetentionOutputTTLs: map[string]time.Duration{"audit": 0},
+		RetentionRowTTL:     2 * time.Hour,
+		RetentionArchive:    true,
+	}
+	sweeper := NewSweeper(conf, slog.New(slog.NewTextHandler(os.Stdout, nil)), dataStore, blobStore)
+
+	summary, err := sweeper.sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Summary{OutputsDeleted: 2, RowsRemoved: 1}, summary)
+
+	_, err = blobStore.Stat(ctx, *expired.OutputFilePath)
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+	_, err = dataStore.Reports.ByPrimaryKey(ctx, user.ID, expired.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	var archived int
+	require.NoError(t, env.DB.QueryRowContext(ctx, `SELECT count(*) FROM reports_archive WHERE id = $1`, expired.ID).Scan(&archived))
+	require.Equal(t, 1, archived)
+
+	report, err := dataStore.Reports.ByPrimaryKey(ctx, user.ID, outputOnly.ID)
+	require.NoError(t, err)
+	require.Nil(t, report.OutputFilePath)
+	require.Nil(t, report.DownloadUrl)
+	require.NotNil(t, report.OutputExpiredAt)
+	_, err = blobStore.Stat(ctx, *outputOnly.OutputFilePath)
+	require.NoError(t, err)
+
+	for _, r := range []*store.Report{kept, recent} {
+		report, err := dataStore.Reports.ByPrimaryKey(ctx, user.ID, r.ID)
+		require.NoError(t, err)
+		require.NotNil(t, report.OutputFilePath)
+		_, err = blobStore.Stat(ctx, *report.OutputFilePath)
+		require.NoError(t, err)
+	}
+
+	summary, err = sweeper.sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Summary{}, summary)
+}
@@ -0,0 +1,25 @@
+package retry
+
+import "time"
+
+// Policy decides how often and when a failed attempt, of a report or a webhook delivery, is made again
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Backoff returns the delay before the attempt following the given (1-based) attempt.
+// The delay doubles with every attempt and is capped at MaxDelay.
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Exhausted reports whether no attempts are left after the given (1-based) attempt
+func (p Policy) Exhausted(attempt int) bool {
+	return attempt >= p.MaxAttempts
+}
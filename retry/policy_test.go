@@ -0,0 +1,26 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    time.Minute,
+	}
+
+	require.Equal(t, 10*time.Second, policy.Backoff(1))
+	require.Equal(t, 20*time.Second, policy.Backoff(2))
+	require.Equal(t, 40*time.Second, policy.Backoff(3))
+	require.Equal(t, time.Minute, policy.Backoff(4))
+	require.Equal(t, time.Minute, policy.Backoff(100))
+
+	require.False(t, policy.Exhausted(1))
+	require.False(t, policy.Exhausted(2))
+	require.True(t, policy.Exhausted(3))
+}
@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule is a standard five field cron expression evaluated in a time zone
+type Schedule struct {
+	cron     cron.Schedule
+	location *time.Location
+}
+
+// ParseSchedule parses a cron expression such as "0 7 * * MON-FRI" or "@daily" in the IANA time zone.
+// The zone is only set by timezone, expressions with a TZ= or CRON_TZ= prefix are rejected, and so are
+// "@every" intervals, which can run more than once a minute.
+func ParseSchedule(expression, timezone string) (Schedule, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	trimmed := strings.TrimSpace(expression)
+	if strings.HasPrefix(trimmed, "TZ=") || strings.HasPrefix(trimmed, "CRON_TZ=") {
+		return Schedule{}, fmt.Errorf("invalid cron expression %q: set the time zone with the timezone field", expression)
+	}
+	if strings.HasPrefix(trimmed, "@every") {
+		return Schedule{}, fmt.Errorf("invalid cron expression %q: @every is not supported", expression)
+	}
+	spec, err := cron.ParseStandard(expression)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+	}
+	return Schedule{cron: spec, location: location}, nil
+}
+
+// Next returns the first run strictly after t
+func (s Schedule) Next(t time.Time) time.Time {
+	return s.cron.Next(t.In(s.location))
+}
@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"async_api/config"
+	"async_api/store"
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// MissedRunsSkip creates one report for a schedule that missed several runs, e.g. while no scheduler was running
+	MissedRunsSkip = "skip"
+	// MissedRunsCatchUp creates a report for every missed run, up to SchedulerMaxCatchUpRuns
+	MissedRunsCatchUp = "catch_up"
+)
+
+// Number of schedules run per transaction
+const schedulerBatchSize = 100
+
+// Scheduler creates the reports of due report schedules. Several schedulers can run at the same time.
+type Scheduler struct {
+	config *config.Config
+	logger *slog.Logger
+	store  *store.Store
+}
+
+func New(config *config.Config, logger *slog.Logger, store *store.Store) *Scheduler {
+	return &Scheduler{
+		config: config,
+		logger: logger,
+		store:  store,
+	}
+}
+
+// Start runs the due schedules every SchedulerInterval until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.logger.Info("starting report scheduler", "interval", s.config.SchedulerInterval, "missed_runs", s.config.SchedulerMissedRuns)
+	ticker := time.NewTicker(s.config.SchedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.run(ctx)
+		}
+	}
+}
+
+// run creates the reports of due schedules in batches until none is due
+func (s *Scheduler) run(ctx context.Context) {
+	for {
+		now := time.Now()
+		quota := store.ReportQuota{MaxActive: s.config.ReportQuotaActive, MaxDaily: s.config.ReportQuotaDaily}
+		reports, skipped, err := s.store.Schedules.RunDue(ctx, now, schedulerBatchSize, quota, func(schedule store.ReportSchedule) (store.ScheduledRuns, error) {
+			runs, err := s.plan(schedule, now)
+			if err != nil {
+				s.logger.Error("failed to plan report schedule, disabling it", "error", err, "schedule_id", schedule.ID, "user_id", schedule.UserID)
+			}
+			return runs, err
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error("failed to run report schedules", "error", err)
+			}
+			return
+		}
+		for _, report := range reports {
+			s.logger.Info("created scheduled report", "report_id", report.ID, "user_id", report.UserID, "report_type", report.ReportType)
+		}
+		for _, run := range skipped {
+			s.logger.Warn("skipping run of report schedule", "error", run.Err, "schedule_id", run.ScheduleID, "user_id", run.UserID, "run_at", run.RunAt)
+		}
+		if len(reports) == 0 && len(skipped) == 0 {
+			return
+		}
+	}
+}
+
+// plan returns the runs of a schedule due at now and its first run after now. A schedule whose
+// cron expression never matches, like "0 0 30 2 *", is disabled without runs.
+func (s *Scheduler) plan(schedule store.ReportSchedule, now time.Time) (store.ScheduledRuns, error) {
+	spec, err := ParseSchedule(schedule.CronExpression, schedule.Timezone)
+	if err != nil {
+		return store.ScheduledRuns{}, err
+	}
+	if spec.Next(now).IsZero() {
+		s.logger.Warn("disabling report schedule that never runs", "schedule_id", schedule.ID, "cron_expression", schedule.CronExpression)
+		return store.ScheduledRuns{NextRunAt: schedule.NextRunAt, Disable: true}, nil
+	}
+
+	runs := []time.Time{schedule.NextRunAt}
+	next := spec.Next(schedule.NextRunAt)
+	if s.config.SchedulerMissedRuns == MissedRunsCatchUp {
+		for !next.After(now) && len(runs) < s.config.SchedulerMaxCatchUpRuns {
+			runs = append(runs, next)
+			next = spec.Next(next)
+		}
+	}
+	if !next.After(now) {
+		next = spec.Next(now)
+		s.logger.Warn("skipping missed runs of report schedule", "schedule_id", schedule.ID, "runs", len(runs), "next_run_at", next)
+	}
+
+	return store.ScheduledRuns{Runs: runs, NextRunAt: next}, nil
+}
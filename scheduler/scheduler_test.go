@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"async_api/config"
+	"async_api/store"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	spec, err := ParseSchedule("0 7 * * *", "Europe/Berlin")
+	require.NoError(t, err)
+	// 07:00 in Berlin is 05:00 UTC in summer time
+	next := spec.Next(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+	require.Equal(t, time.Date(2024, 7, 2, 5, 0, 0, 0, time.UTC), next.UTC())
+
+	_, err = ParseSchedule("0 7 * *", "UTC")
+	require.Error(t, err)
+	_, err = ParseSchedule("0 7 * * *", "Mars/Olympus")
+	require.Error(t, err)
+	// the zone is only set by the timezone field and runs are at most once a minute
+	_, err = ParseSchedule("CRON_TZ=Asia/Tokyo 0 7 * * *", "UTC")
+	require.Error(t, err)
+	_, err = ParseSchedule("TZ=Asia/Tokyo 0 7 * * *", "UTC")
+	require.Error(t, err)
+	_, err = ParseSchedule("@every 1s", "UTC")
+	require.Error(t, err)
+	_, err = ParseSchedule("@hourly", "UTC")
+	require.NoError(t, err)
+}
+
+func TestSchedulerPlan(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	schedule := store.ReportSchedule{
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		NextRunAt:      time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC),
+	}
+	now := time.Date(2024, 7, 1, 11, 30, 0, 0, time.UTC)
+
+	skip := New(&config.Config{SchedulerMissedRuns: MissedRunsSkip}, logger, nil)
+	runs, err := skip.plan(schedule, now)
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{schedule.NextRunAt}, runs.Runs)
+	require.Equal(t, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), runs.NextRunAt.UTC())
+
+	catchUp := New(&config.Config{SchedulerMissedRuns: MissedRunsCatchUp, SchedulerMaxCatchUpRuns: 10}, logger, nil)
+	runs, err = catchUp.plan(schedule, now)
+	require.NoError(t, err)
+	require.Len(t, runs.Runs, 4)
+	require.Equal(t, time.Date(2024, 7, 1, 11, 0, 0, 0, time.UTC), runs.Runs[3].UTC())
+	require.Equal(t, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), runs.NextRunAt.UTC())
+
+	limited := New(&config.Config{SchedulerMissedRuns: MissedRunsCatchUp, SchedulerMaxCatchUpRuns: 2}, logger, nil)
+	runs, err = limited.plan(schedule, now)
+	require.NoError(t, err)
+	require.Len(t, runs.Runs, 2)
+	require.Equal(t, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), runs.NextRunAt.UTC())
+
+	// a schedule that never matches is disabled instead of running
+	never := schedule
+	never.CronExpression = "0 0 30 2 *"
+	runs, err = skip.plan(never, now)
+	require.NoError(t, err)
+	require.True(t, runs.Disable)
+	require.Empty(t, runs.Runs)
+
+	// a run that is due on time is not a missed run
+	schedule.NextRunAt = time.Date(2024, 7, 1, 11, 0, 0, 0, time.UTC)
+	runs, err = skip.plan(schedule, now)
+	require.NoError(t, err)
+	require.Len(t, runs.Runs, 1)
+	require.Equal(t, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC), runs.NextRunAt.UTC())
+}
@@ -6,6 +6,8 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func NewPostgresDB(conf *config.Config) (*sql.DB, error) {
@@ -24,3 +26,20 @@ func NewPostgresDB(conf *config.Config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// withTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise
+func withTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ReportFingerprint identifies the result of a report by its type and parameters. The parameters
+// are normalized, so the order of the keys and the formatting of the JSON do not matter.
+func ReportFingerprint(reportType string, parameters json.RawMessage) (string, error) {
+	if len(bytes.TrimSpace(parameters)) == 0 {
+		parameters = json.RawMessage(`{}`)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(parameters))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", fmt.Errorf("failed to decode report parameters: %w", err)
+	}
+	// maps are encoded with sorted keys
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode report parameters: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", reportType)
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// createDeduplicated creates a report that reuses the output of an identical report generated within
+// opts.CacheTTL, or follows an identical report in flight if opts.Coalesce is set. Otherwise it creates
+// a queued report like createReport.
+func createDeduplicated(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, reportType string, parameters json.RawMessage, opts CreateOptions) (*Report, error) {
+	const (
+		lockStmt   = `SELECT pg_advisory_xact_lock(hashtextextended($1, 0));`
+		cachedStmt = `SELECT * FROM reports
+		WHERE fingerprint = $1 AND source_report_id IS NULL AND output_file_path IS NOT NULL
+			AND completed_at > CURRENT_TIMESTAMP - make_interval(secs => $2)
+		ORDER BY completed_at DESC
+		LIMIT 1;`
+		// a report does not wait for a report in a lower priority lane
+		inFlightStmt = `SELECT * FROM reports
+		WHERE fingerprint = $1 AND source_report_id IS NULL
+			AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL
+			AND array_position($2::text[], priority) >= array_position($2::text[], $3::text)
+		ORDER BY created_at
+		LIMIT 1;`
+		attachStmt = `INSERT INTO reports (user_id, report_type, parameters, fingerprint, priority, source_report_id,
+			output_file_path, output_size, output_checksum, download_url, download_url_expires_at, completed_at, progress_percent, progress_updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CURRENT_TIMESTAMP, 100, CURRENT_TIMESTAMP) RETURNING *;`
+		followStmt = `INSERT INTO reports (user_id, report_type, parameters, fingerprint, priority, source_report_id)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING *;`
+	)
+	if len(parameters) == 0 {
+		parameters = json.RawMessage(`{}`)
+	}
+	fingerprint, err := ReportFingerprint(reportType, parameters)
+	if err != nil {
+		return nil, err
+	}
+	// identical creates wait for each other, so the second one finds the report of the first
+	if _, err := tx.ExecContext(ctx, lockStmt, fingerprint); err != nil {
+		return nil, fmt.Errorf("failed to lock report fingerprint: %w", err)
+	}
+
+	var source Report
+	if opts.CacheTTL > 0 {
+		err := tx.GetContext(ctx, &source, cachedStmt, fingerprint, opts.CacheTTL.Seconds())
+		if err == nil {
+			var report Report
+			if err := tx.GetContext(ctx, &report, attachStmt, userID, reportType, parameters, fingerprint, opts.Priority, source.ID,
+				source.OutputFilePath, source.OutputSize, source.OutputChecksum, source.DownloadUrl, source.DownloadUrlExpiresAt); err != nil {
+				return nil, err
+			}
+			if err := notifyReportEvent(ctx, tx, &report); err != nil {
+				return nil, err
+			}
+			if err := enqueueWebhookDeliveries(ctx, tx, &report); err != nil {
+				return nil, err
+			}
+			return &report, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to look up cached report: %w", err)
+		}
+	}
+
+	if opts.Coalesce {
+		err := tx.GetContext(ctx, &source, inFlightStmt, fingerprint, Priorities, opts.Priority)
+		if err == nil {
+			// the report has no job, it is settled when its source finishes
+			var report Report
+			if err := tx.GetContext(ctx, &report, followStmt, userID, reportType, parameters, fingerprint, opts.Priority, source.ID); err != nil {
+				return nil, err
+			}
+			if err := notifyReportEvent(ctx, tx, &report); err != nil {
+				return nil, err
+			}
+			return &report, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to look up report in flight: %w", err)
+		}
+	}
+
+	return createReport(ctx, tx, userID, reportType, parameters, opts.Priority)
+}
+
+// settleFollowers hands the outcome of a finished report to the reports waiting for it. They complete
+// with its output, or are queued on their own if it failed or was cancelled, since the owner of the
+// source may not be their owner.
+func settleFollowers(ctx context.Context, tx *sqlx.Tx, source *Report) error {
+	const (
+		completeStmt = `UPDATE reports SET completed_at = CURRENT_TIMESTAMP, output_file_path = $2, output_size = $3, output_checksum = $4,
+		download_url = $5, download_url_expires_at = $6, progress_percent = 100, progress_updated_at = CURRENT_TIMESTAMP
+		WHERE source_report_id = $1 AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+		detachStmt = `UPDATE reports SET source_report_id = NULL
+		WHERE source_report_id = $1 AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	)
+	if !source.Finished() {
+		return nil
+	}
+
+	followers := []Report{}
+	if source.CompletedAt != nil {
+		if err := tx.SelectContext(ctx, &followers, completeStmt, source.ID, source.OutputFilePath, source.OutputSize, source.OutputChecksum,
+			source.DownloadUrl, source.DownloadUrlExpiresAt); err != nil {
+			return fmt.Errorf("failed to complete reports following %s: %w", source.ID, err)
+		}
+		for _, report := range followers {
+			if err := notifyReportEvent(ctx, tx, &report); err != nil {
+				return err
+			}
+			if err := enqueueWebhookDeliveries(ctx, tx, &report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := tx.SelectContext(ctx, &followers, detachStmt, source.ID); err != nil {
+		return fmt.Errorf("failed to detach reports following %s: %w", source.ID, err)
+	}
+	for _, report := range followers {
+		if err := enqueueReportJob(ctx, tx, &report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OutputShared reports whether a report other than the given one references outputFilePath,
+// reports created from the cache share the output of their source
+func (s *ReportStore) OutputShared(ctx context.Context, userID, id uuid.UUID, outputFilePath string) (bool, error) {
+	const stmt = `SELECT EXISTS (
+		SELECT 1 FROM reports WHERE output_file_path = $3 AND NOT (user_id = $1 AND id = $2)
+	);`
+	var shared bool
+	if err := s.db.GetContext(ctx, &shared, stmt, userID, id, outputFilePath); err != nil {
+		return false, fmt.Errorf("failed to check whether output of report %s is shared: %w", id, err)
+	}
+
+	return shared, nil
+}
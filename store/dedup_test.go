@@ -0,0 +1,136 @@
+package store_test
+
+import (
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportFingerprint(t *testing.T) {
+	fingerprint, err := store.ReportFingerprint("sample", json.RawMessage(`{"rows": 10, "title": "a"}`))
+	require.NoError(t, err)
+
+	same, err := store.ReportFingerprint("sample", json.RawMessage(`{"title":"a","rows":10}`))
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, same)
+
+	other, err := store.ReportFingerprint("audit", json.RawMessage(`{"rows": 10, "title": "a"}`))
+	require.NoError(t, err)
+	require.NotEqual(t, fingerprint, other)
+
+	empty, err := store.ReportFingerprint("sample", nil)
+	require.NoError(t, err)
+	emptyObject, err := store.ReportFingerprint("sample", json.RawMessage(`{}`))
+	require.NoError(t, err)
+	require.Equal(t, empty, emptyObject)
+
+	_, err = store.ReportFingerprint("sample", json.RawMessage(`{`))
+	require.Error(t, err)
+}
+
+func TestReportStoreDeduplication(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+	opts := store.CreateOptions{CacheTTL: time.Hour, Coalesce: true}
+
+	user1, err := dataStore.Users.CreateUser(ctx, "test1@test.com", "testingpassword")
+	require.NoError(t, err)
+	user2, err := dataStore.Users.CreateUser(ctx, "test2@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	countJobs := func() int {
+		var count int
+		require.NoError(t, env.DB.QueryRowContext(ctx, `SELECT count(*) FROM outbox`).Scan(&count))
+		return count
+	}
+
+	source, err := dataStore.Reports.Create(ctx, user1.ID, "sample", json.RawMessage(`{"rows": 10}`), opts)
+	require.NoError(t, err)
+	require.Nil(t, source.SourceReportID)
+	require.NotNil(t, source.Fingerprint)
+	require.Equal(t, 1, countJobs())
+
+	// an identical report in flight is followed without a job of its own
+	follower, err := dataStore.Reports.Create(ctx, user2.ID, "sample", json.RawMessage(`{ "rows":10 }`), opts)
+	require.NoError(t, err)
+	require.Equal(t, &source.ID, follower.SourceReportID)
+	require.Equal(t, store.ReportStatusQueued, follower.Status())
+	require.Equal(t, 1, countJobs())
+
+	// a report does not wait for an identical report of a lower priority
+	high, err := dataStore.Reports.Create(ctx, user2.ID, "sample", json.RawMessage(`{"rows": 10}`), store.CreateOptions{Coalesce: true, Priority: store.PriorityHigh})
+	require.NoError(t, err)
+	require.Nil(t, high.SourceReportID)
+	require.Equal(t, store.PriorityHigh, high.Priority)
+	_, err = dataStore.Reports.Cancel(ctx, user2.ID, high.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, countJobs())
+
+	other, err := dataStore.Reports.Create(ctx, user2.ID, "sample", json.RawMessage(`{"rows": 20}`), opts)
+	require.NoError(t, err)
+	require.Nil(t, other.SourceReportID)
+	require.Equal(t, 3, countJobs())
+
+	_, err = dataStore.Reports.MarkStarted(ctx, user1.ID, source.ID)
+	require.NoError(t, err)
+	source, err = dataStore.Reports.MarkCompleted(ctx, user1.ID, source.ID, 1, store.Output{FilePath: "reports/source.csv", Size: 10, Checksum: "checksum"}, "http://download", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	follower, err = dataStore.Reports.ByPrimaryKey(ctx, user2.ID, follower.ID)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusCompleted, follower.Status())
+	require.Equal(t, source.OutputFilePath, follower.OutputFilePath)
+	require.Equal(t, source.OutputChecksum, follower.OutputChecksum)
+	require.Equal(t, 100, follower.ProgressPercent)
+
+	// a fresh result is attached right away
+	cached, err := dataStore.Reports.Create(ctx, user2.ID, "sample", json.RawMessage(`{"rows": 10}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusCompleted, cached.Status())
+	require.Equal(t, source.OutputFilePath, cached.OutputFilePath)
+	require.Equal(t, source.OutputSize, cached.OutputSize)
+	require.Equal(t, 3, countJobs())
+
+	shared, err := dataStore.Reports.OutputShared(ctx, user1.ID, source.ID, *source.OutputFilePath)
+	require.NoError(t, err)
+	require.True(t, shared)
+
+	// without the options every report is generated
+	uncached, err := dataStore.Reports.Create(ctx, user2.ID, "sample", json.RawMessage(`{"rows": 10}`), store.CreateOptions{})
+	require.NoError(t, err)
+	require.Nil(t, uncached.SourceReportID)
+	require.Equal(t, 4, countJobs())
+
+	// followers of a cancelled report are queued on their own
+	follower, err = dataStore.Reports.Create(ctx, user1.ID, "sample", json.RawMessage(`{"rows": 20}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, &other.ID, follower.SourceReportID)
+	_, err = dataStore.Reports.Cancel(ctx, user2.ID, other.ID)
+	require.NoError(t, err)
+
+	follower, err = dataStore.Reports.ByPrimaryKey(ctx, user1.ID, follower.ID)
+	require.NoError(t, err)
+	require.Nil(t, follower.SourceReportID)
+	require.Equal(t, store.ReportStatusQueued, follower.Status())
+	require.Equal(t, 5, countJobs())
+
+	// copies of a result do not keep it fresh, it expires with the generated report
+	_, err = env.DB.ExecContext(ctx, `UPDATE reports SET completed_at = completed_at - interval '2 hours' WHERE id = $1;`, source.ID)
+	require.NoError(t, err)
+	regenerated, err := dataStore.Reports.Create(ctx, user2.ID, "sample", json.RawMessage(`{"rows": 10}`), store.CreateOptions{CacheTTL: time.Hour})
+	require.NoError(t, err)
+	require.Nil(t, regenerated.SourceReportID)
+	require.Equal(t, store.ReportStatusQueued, regenerated.Status())
+	require.Equal(t, 6, countJobs())
+}
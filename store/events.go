@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ReportEventsChannel is the channel of the NOTIFY sent on every state change of a report
+const ReportEventsChannel = "report_events"
+
+// ReportEvent is the payload of a report notification. It is kept small, listeners
+// that need more than the status read the report.
+type ReportEvent struct {
+	UserID   uuid.UUID `json:"user_id"`
+	ReportID uuid.UUID `json:"report_id"`
+	Status   string    `json:"status"`
+}
+
+// notifyReportEvent sends the state of report to the listeners of ReportEventsChannel.
+// Postgres delivers the notification when the transaction commits and drops it on rollback.
+func notifyReportEvent(ctx context.Context, tx sqlx.ExecerContext, report *Report) error {
+	payload, err := json.Marshal(ReportEvent{
+		UserID:   report.UserID,
+		ReportID: report.ID,
+		Status:   report.Status(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode report event: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2);`, ReportEventsChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to notify report event: %w", err)
+	}
+	return nil
+}
+
+// updateAndNotify runs a statement returning one report row and, in the same transaction, notifies
+// the listeners of report events about its new state, queues the webhook deliveries of a finished report
+// and settles the reports waiting for it
+func (s *ReportStore) updateAndNotify(ctx context.Context, stmt string, args ...any) (*Report, error) {
+	var report Report
+	if err := withTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &report, stmt, args...); err != nil {
+			return err
+		}
+		if err := notifyReportEvent(ctx, tx, &report); err != nil {
+			return err
+		}
+		if err := enqueueWebhookDeliveries(ctx, tx, &report); err != nil {
+			return err
+		}
+		return settleFollowers(ctx, tx, &report)
+	}); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
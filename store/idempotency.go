@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type IdempotencyStore struct {
+	db *sqlx.DB
+}
+
+func NewIdempotencyStore(db *sql.DB) *IdempotencyStore {
+	return &IdempotencyStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// IdempotencyKey is the first request sent with a key and, once it finished, its response.
+// Keys of requests without a user, e.g. signups, are stored under the nil uuid.
+type IdempotencyKey struct {
+	UserID          uuid.UUID       `db:"user_id"`
+	Key             string          `db:"key"`
+	Fingerprint     string          `db:"fingerprint"`
+	StatusCode      *int            `db:"status_code"`
+	ResponseHeaders json.RawMessage `db:"response_headers"`
+	ResponseBody    []byte          `db:"response_body"`
+	LockedAt        time.Time       `db:"locked_at"`
+	CreatedAt       time.Time       `db:"created_at"`
+	CompletedAt     *time.Time      `db:"completed_at"`
+}
+
+// Completed reports whether the response of the request is stored
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != nil
+}
+
+// Begin claims the key for a request with fingerprint. Keys older than ttl are forgotten first, and a key
+// whose request has been in flight for longer than lockTimeout is taken over by a request with the same
+// fingerprint, in case the first one never finished. It returns the key and whether the caller holds it,
+// otherwise the key belongs to another request or already has a response.
+func (s *IdempotencyStore) Begin(ctx context.Context, userID uuid.UUID, key, fingerprint string, ttl, lockTimeout time.Duration) (*IdempotencyKey, bool, error) {
+	const (
+		deleteExpiredStmt = `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2
+		AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $3);`
+		insertStmt = `INSERT INTO idempotency_keys (user_id, key, fingerprint) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING RETURNING *;`
+		takeOverStmt = `UPDATE idempotency_keys SET locked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND key = $2 AND fingerprint = $3 AND status_code IS NULL
+			AND locked_at < CURRENT_TIMESTAMP - make_interval(secs => $4)
+		RETURNING *;`
+		selectStmt = `SELECT * FROM idempotency_keys WHERE user_id = $1 AND key = $2;`
+	)
+	var (
+		idempotencyKey IdempotencyKey
+		acquired       bool
+	)
+	err := withTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, deleteExpiredStmt, userID, key, ttl.Seconds()); err != nil {
+			return err
+		}
+
+		err := tx.GetContext(ctx, &idempotencyKey, insertStmt, userID, key, fingerprint)
+		if err == nil {
+			acquired = true
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		err = tx.GetContext(ctx, &idempotencyKey, takeOverStmt, userID, key, fingerprint, lockTimeout.Seconds())
+		if err == nil {
+			acquired = true
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		return tx.GetContext(ctx, &idempotencyKey, selectStmt, userID, key)
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin idempotency key %q for user %s: %w", key, userID, err)
+	}
+
+	return &idempotencyKey, acquired, nil
+}
+
+// Complete stores the response of the request holding the key
+func (s *IdempotencyStore) Complete(ctx context.Context, userID uuid.UUID, key string, statusCode int, headers json.RawMessage, body []byte) (*IdempotencyKey, error) {
+	const stmt = `UPDATE idempotency_keys SET status_code = $3, response_headers = $4, response_body = $5, completed_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND key = $2 AND status_code IS NULL RETURNING *;`
+	if len(headers) == 0 {
+		headers = json.RawMessage(`{}`)
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	var idempotencyKey IdempotencyKey
+	if err := s.db.GetContext(ctx, &idempotencyKey, stmt, userID, key, statusCode, headers, body); err != nil {
+		return nil, fmt.Errorf("failed to complete idempotency key %q for user %s: %w", key, userID, err)
+	}
+
+	return &idempotencyKey, nil
+}
+
+// Release forgets a key whose request did not complete, so a retry runs the request again
+func (s *IdempotencyStore) Release(ctx context.Context, userID uuid.UUID, key string) error {
+	const stmt = `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL;`
+	if _, err := s.db.ExecContext(ctx, stmt, userID, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key %q for user %s: %w", key, userID, err)
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes up to limit keys older than ttl and returns the number of deleted keys
+func (s *IdempotencyStore) DeleteExpired(ctx context.Context, ttl time.Duration, limit int) (int, error) {
+	const stmt = `DELETE FROM idempotency_keys WHERE (user_id, key) IN (
+		SELECT user_id, key FROM idempotency_keys
+		WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+		ORDER BY created_at
+		LIMIT $2
+	);`
+	res, err := s.db.ExecContext(ctx, stmt, ttl.Seconds(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted idempotency keys: %w", err)
+	}
+
+	return int(deleted), nil
+}
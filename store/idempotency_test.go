@@ -0,0 +1,80 @@
+package store_test
+
+import (
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+	userID := uuid.New()
+
+	key, acquired, err := dataStore.Idempotency.Begin(ctx, userID, "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	require.False(t, key.Completed())
+
+	// the first request is still in flight
+	key, acquired, err = dataStore.Idempotency.Begin(ctx, userID, "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.False(t, key.Completed())
+
+	// keys are scoped per user
+	_, acquired, err = dataStore.Idempotency.Begin(ctx, uuid.Nil, "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	key, err = dataStore.Idempotency.Complete(ctx, userID, "key-1", http.StatusCreated, json.RawMessage(`{"X-Test":["1"]}`), []byte(`{"message":"ok"}`))
+	require.NoError(t, err)
+	require.True(t, key.Completed())
+
+	key, acquired, err = dataStore.Idempotency.Begin(ctx, userID, "key-1", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.Equal(t, "fingerprint", key.Fingerprint)
+	require.Equal(t, http.StatusCreated, *key.StatusCode)
+	require.Equal(t, []byte(`{"message":"ok"}`), key.ResponseBody)
+	require.JSONEq(t, `{"X-Test":["1"]}`, string(key.ResponseHeaders))
+
+	// a released key is claimed again by the retry
+	require.NoError(t, dataStore.Idempotency.Release(ctx, uuid.Nil, "key-1"))
+	_, acquired, err = dataStore.Idempotency.Begin(ctx, uuid.Nil, "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// a request in flight for longer than the lock timeout is taken over by a retry, not by another request
+	_, err = env.DB.ExecContext(ctx, `UPDATE idempotency_keys SET locked_at = locked_at - interval '2 minutes' WHERE user_id = $1;`, uuid.Nil)
+	require.NoError(t, err)
+	_, acquired, err = dataStore.Idempotency.Begin(ctx, uuid.Nil, "key-1", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	_, acquired, err = dataStore.Idempotency.Begin(ctx, uuid.Nil, "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// expired keys are forgotten
+	_, err = env.DB.ExecContext(ctx, `UPDATE idempotency_keys SET created_at = created_at - interval '2 hours' WHERE user_id = $1;`, userID)
+	require.NoError(t, err)
+	deleted, err := dataStore.Idempotency.DeleteExpired(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	_, acquired, err = dataStore.Idempotency.Begin(ctx, userID, "key-1", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type OutboxStore struct {
+	db *sqlx.DB
+}
+
+func NewOutboxStore(db *sql.DB) *OutboxStore {
+	return &OutboxStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// OutboxMessage is a report job waiting to be published.
+// The payload has the user_id and report_id of the report to generate.
+type OutboxMessage struct {
+	ID        int64           `db:"id"`
+	DedupKey  uuid.UUID       `db:"dedup_key"`
+	Payload   json.RawMessage `db:"payload"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+// enqueueReportJob adds the job of a report to the outbox, it must run in the transaction changing the report.
+// The priority of the report selects the queue lane of the job.
+func enqueueReportJob(ctx context.Context, tx sqlx.ExecerContext, report *Report) error {
+	const stmt = `INSERT INTO outbox (payload) VALUES (jsonb_build_object('user_id', $1::uuid, 'report_id', $2::uuid, 'priority', $3::text));`
+	if _, err := tx.ExecContext(ctx, stmt, report.UserID, report.ID, report.Priority); err != nil {
+		return fmt.Errorf("failed to insert outbox message: %w", err)
+	}
+	return nil
+}
+
+// Drain hands up to limit messages to publish, oldest first, and deletes the ones published successfully.
+// It stops at the first failure, the remaining messages are handed out again by a later call.
+// Messages locked by a concurrent Drain are skipped. A message can be published more than once
+// if the deletion fails, consumers recognize it by its dedup key.
+func (s *OutboxStore) Drain(ctx context.Context, limit int, publish func(msg OutboxMessage) error) (int, error) {
+	const selectStmt = `SELECT * FROM outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED;`
+	const deleteStmt = `DELETE FROM outbox WHERE id = $1;`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var msgs []OutboxMessage
+	if err := tx.SelectContext(ctx, &msgs, selectStmt, limit); err != nil {
+		return 0, fmt.Errorf("failed to fetch outbox messages: %w", err)
+	}
+
+	published := 0
+	var publishErr error
+	for _, msg := range msgs {
+		if publishErr = publish(msg); publishErr != nil {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, deleteStmt, msg.ID); err != nil {
+			return 0, fmt.Errorf("failed to delete outbox message %d: %w", msg.ID, err)
+		}
+		published++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox deletions: %w", err)
+	}
+	if publishErr != nil {
+		return published, fmt.Errorf("failed to publish outbox message: %w", publishErr)
+	}
+	return published, nil
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Priorities of reports, their jobs are consumed from one queue lane per priority
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// Priorities are ordered from the lowest to the highest
+var Priorities = []string{PriorityLow, PriorityNormal, PriorityHigh}
+
+// ValidatePriority returns an error if priority is not one of Priorities
+func ValidatePriority(priority string) error {
+	if !slices.Contains(Priorities, priority) {
+		return fmt.Errorf("priority must be one of %v", Priorities)
+	}
+	return nil
+}
+
+// PriorityAllowed reports whether priority is not above maxPriority
+func PriorityAllowed(priority, maxPriority string) bool {
+	return slices.Index(Priorities, priority) <= slices.Index(Priorities, maxPriority)
+}
+
+// ClampPriority lowers priority to maxPriority if it is above it
+func ClampPriority(priority, maxPriority string) string {
+	if PriorityAllowed(priority, maxPriority) {
+		return priority
+	}
+	return maxPriority
+}
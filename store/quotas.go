@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	QuotaLimitActive = "active"
+	QuotaLimitDaily  = "daily"
+
+	// Window of the daily quota, it rolls with the creation time of the reports
+	quotaDailyWindow = 24 * time.Hour
+)
+
+// ErrQuotaExceeded is matched by every QuotaExceededError
+var ErrQuotaExceeded = errors.New("report quota exceeded")
+
+// ReportQuota limits the reports of a user. MaxActive is the number of queued or running reports and
+// MaxDaily the number of reports created within a day, zero disables the limit.
+type ReportQuota struct {
+	MaxActive int
+	MaxDaily  int
+}
+
+// QuotaExceededError is returned when creating a report would exceed Limit. RetryAfter is when the
+// daily quota allows a report again, it is zero for the active quota which depends on the workers.
+type QuotaExceededError struct {
+	Limit      string
+	Max        int
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s report quota of %d exceeded", e.Limit, e.Max)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// checkQuota returns a QuotaExceededError if the user can not create another report. It locks the user
+// row, so concurrent creates of the user wait for tx and see the report it creates.
+func checkQuota(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, quota ReportQuota) error {
+	const (
+		lockStmt   = `SELECT id FROM users WHERE id = $1 FOR UPDATE;`
+		activeStmt = `SELECT COUNT(*) FROM reports WHERE user_id = $1
+		AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL;`
+		dailyStmt = `SELECT COUNT(*) FROM reports WHERE user_id = $1
+		AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $2);`
+		// the report that has to leave the window before the count drops below the quota
+		retryAfterStmt = `SELECT EXTRACT(EPOCH FROM created_at + make_interval(secs => $2) - CURRENT_TIMESTAMP)::float8 FROM reports
+		WHERE user_id = $1 AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $2)
+		ORDER BY created_at
+		OFFSET $3 LIMIT 1;`
+	)
+	if quota.MaxActive <= 0 && quota.MaxDaily <= 0 {
+		return nil
+	}
+
+	var id uuid.UUID
+	if err := tx.GetContext(ctx, &id, lockStmt, userID); err != nil {
+		return fmt.Errorf("failed to lock user %s: %w", userID, err)
+	}
+
+	if quota.MaxActive > 0 {
+		var active int
+		if err := tx.GetContext(ctx, &active, activeStmt, userID); err != nil {
+			return fmt.Errorf("failed to count active reports: %w", err)
+		}
+		if active >= quota.MaxActive {
+			return &QuotaExceededError{Limit: QuotaLimitActive, Max: quota.MaxActive}
+		}
+	}
+
+	if quota.MaxDaily > 0 {
+		window := quotaDailyWindow.Seconds()
+		var created int
+		if err := tx.GetContext(ctx, &created, dailyStmt, userID, window); err != nil {
+			return fmt.Errorf("failed to count reports created today: %w", err)
+		}
+		if created >= quota.MaxDaily {
+			var seconds float64
+			if err := tx.GetContext(ctx, &seconds, retryAfterStmt, userID, window, created-quota.MaxDaily); err != nil {
+				return fmt.Errorf("failed to compute when the daily quota resets: %w", err)
+			}
+			return &QuotaExceededError{Limit: QuotaLimitDaily, Max: quota.MaxDaily, RetryAfter: time.Duration(seconds * float64(time.Second))}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package store_test
+
+import (
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportStoreQuota(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	// concurrent creates can not race past the active quota
+	quota := store.ReportQuota{MaxActive: 2, MaxDaily: 3}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		created  int
+		exceeded int
+	)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{Quota: quota})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				created++
+				return
+			}
+			var quotaErr *store.QuotaExceededError
+			if assert.ErrorAs(t, err, &quotaErr) {
+				assert.Equal(t, store.QuotaLimitActive, quotaErr.Limit)
+				assert.Zero(t, quotaErr.RetryAfter)
+			}
+			exceeded++
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, created)
+	require.Equal(t, 3, exceeded)
+
+	// finished reports leave the active quota but still count for the day
+	reports, err := dataStore.Reports.ByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = dataStore.Reports.Cancel(ctx, user.ID, reports[0].ID)
+	require.NoError(t, err)
+
+	_, err = dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{Quota: quota})
+	require.NoError(t, err)
+
+	_, err = dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{Quota: store.ReportQuota{MaxDaily: 3}})
+	require.ErrorIs(t, err, store.ErrQuotaExceeded)
+	var quotaErr *store.QuotaExceededError
+	require.ErrorAs(t, err, &quotaErr)
+	require.Equal(t, store.QuotaLimitDaily, quotaErr.Limit)
+	require.InDelta(t, (24 * time.Hour).Seconds(), quotaErr.RetryAfter.Seconds(), 60)
+
+	// reports created over a day ago do not count
+	_, err = env.DB.ExecContext(ctx, `UPDATE reports SET created_at = created_at - interval '25 hours' WHERE user_id = $1;`, user.ID)
+	require.NoError(t, err)
+	_, err = dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{Quota: store.ReportQuota{MaxDaily: 3}})
+	require.NoError(t, err)
+}
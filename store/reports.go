@@ -0,0 +1,421 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ReportStatusQueued    = "queued"
+	ReportStatusRunning   = "running"
+	ReportStatusCompleted = "completed"
+	ReportStatusFailed    = "failed"
+	// ReportStatusDeadLettered is a report that failed on every attempt, it is only retried on request
+	ReportStatusDeadLettered = "dead_lettered"
+	ReportStatusCancelled    = "cancelled"
+)
+
+type ReportStore struct {
+	db *sqlx.DB
+}
+
+func NewReportStore(db *sql.DB) *ReportStore {
+	return &ReportStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type Report struct {
+	UserID               uuid.UUID       `db:"user_id"`
+	ID                   uuid.UUID       `db:"id"`
+	ReportType           string          `db:"report_type"`
+	Parameters           json.RawMessage `db:"parameters"`
+	OutputFilePath       *string         `db:"output_file_path"`
+	DownloadUrl          *string         `db:"download_url"`
+	DownloadUrlExpiresAt *time.Time      `db:"download_url_expires_at"`
+	ErrorMessage         *string         `db:"error_message"`
+	CreatedAt            time.Time       `db:"created_at"`
+	StartedAt            *time.Time      `db:"started_at"`
+	FailedAt             *time.Time      `db:"failed_at"`
+	CompletedAt          *time.Time      `db:"completed_at"`
+	Attempts             int             `db:"attempts"`
+	AttemptErrors        AttemptErrors   `db:"attempt_errors"`
+	DeadLetteredAt       *time.Time      `db:"dead_lettered_at"`
+	HeartbeatAt          *time.Time      `db:"heartbeat_at"`
+	CancelledAt          *time.Time      `db:"cancelled_at"`
+	ProgressPercent      int             `db:"progress_percent"`
+	ProgressStage        *string         `db:"progress_stage"`
+	RowsProcessed        int64           `db:"rows_processed"`
+	ProgressUpdatedAt    *time.Time      `db:"progress_updated_at"`
+	OutputExpiredAt      *time.Time      `db:"output_expired_at"`
+	Fingerprint          *string         `db:"fingerprint"`
+	// SourceReportID is the identical report whose output this report reuses or waits for
+	SourceReportID *uuid.UUID `db:"source_report_id"`
+	Priority       string     `db:"priority"`
+	// OutputSize and OutputChecksum, the hex encoded sha256, describe the output as it was uploaded
+	OutputSize     *int64  `db:"output_size"`
+	OutputChecksum *string `db:"output_checksum"`
+}
+
+// Output is the uploaded result of a report
+type Output struct {
+	FilePath string
+	Size     int64
+	// Checksum is the hex encoded sha256 of the output
+	Checksum string
+}
+
+type AttemptError struct {
+	Attempt  int       `json:"attempt"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// AttemptErrors is the JSONB list of errors of the failed attempts of a report
+type AttemptErrors []AttemptError
+
+func (a *AttemptErrors) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into AttemptErrors", src)
+	}
+	return json.Unmarshal(data, a)
+}
+
+func (a AttemptErrors) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte(`[]`), nil
+	}
+	return json.Marshal(a)
+}
+
+// Status derives the report status from its lifecycle timestamps
+func (r *Report) Status() string {
+	switch {
+	case r.CompletedAt != nil:
+		return ReportStatusCompleted
+	case r.CancelledAt != nil:
+		return ReportStatusCancelled
+	case r.DeadLetteredAt != nil:
+		return ReportStatusDeadLettered
+	case r.FailedAt != nil:
+		return ReportStatusFailed
+	case r.StartedAt != nil:
+		return ReportStatusRunning
+	default:
+		return ReportStatusQueued
+	}
+}
+
+// Finished reports whether the report reached a state it does not leave on its own
+func (r *Report) Finished() bool {
+	return r.CompletedAt != nil || r.FailedAt != nil || r.CancelledAt != nil
+}
+
+// CreateOptions are the policies applied when a report is created, the zero value applies none
+type CreateOptions struct {
+	// Priority of the report, PriorityNormal if empty
+	Priority string
+	Quota    ReportQuota
+	// CacheTTL attaches the output of an identical report completed within CacheTTL, zero disables it
+	CacheTTL time.Duration
+	// Coalesce makes the report wait for an identical report in flight instead of being generated again
+	Coalesce bool
+}
+
+// Create inserts a new record into reports table, parameters must be a JSON object or empty.
+// The job of the report is added to the outbox in the same transaction. It returns a
+// QuotaExceededError if the user already reached a limit of opts.Quota.
+func (s *ReportStore) Create(ctx context.Context, userID uuid.UUID, reportType string, parameters json.RawMessage, opts CreateOptions) (*Report, error) {
+	var report *Report
+	if opts.Priority == "" {
+		opts.Priority = PriorityNormal
+	}
+	if err := withTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		if err := checkQuota(ctx, tx, userID, opts.Quota); err != nil {
+			return err
+		}
+		var err error
+		if opts.CacheTTL > 0 || opts.Coalesce {
+			report, err = createDeduplicated(ctx, tx, userID, reportType, parameters, opts)
+			return err
+		}
+		report, err = createReport(ctx, tx, userID, reportType, parameters, opts.Priority)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create report record: %w", err)
+	}
+
+	return report, nil
+}
+
+// createReport inserts a queued report, notifies about it and adds its job to the outbox in tx
+func createReport(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID, reportType string, parameters json.RawMessage, priority string) (*Report, error) {
+	const stmt = `INSERT INTO reports (user_id, report_type, parameters, fingerprint, priority) VALUES ($1, $2, $3, $4, $5) RETURNING *;`
+	if len(parameters) == 0 {
+		parameters = json.RawMessage(`{}`)
+	}
+	fingerprint, err := ReportFingerprint(reportType, parameters)
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	if err := tx.GetContext(ctx, &report, stmt, userID, reportType, parameters, fingerprint, priority); err != nil {
+		return nil, err
+	}
+	if err := notifyReportEvent(ctx, tx, &report); err != nil {
+		return nil, err
+	}
+	if err := enqueueReportJob(ctx, tx, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ByPrimaryKey extracts the report record by userID and report id
+func (s *ReportStore) ByPrimaryKey(ctx context.Context, userID, id uuid.UUID) (*Report, error) {
+	const stmt = `SELECT * FROM reports WHERE user_id = $1 AND id = $2;`
+	var report Report
+	if err := s.db.GetContext(ctx, &report, stmt, userID, id); err != nil {
+		return nil, fmt.Errorf("failed to fetch report %s for user %s: %w", id, userID, err)
+	}
+
+	return &report, nil
+}
+
+// ByUserID extracts all report records of the user, newest first
+func (s *ReportStore) ByUserID(ctx context.Context, userID uuid.UUID) ([]Report, error) {
+	const stmt = `SELECT * FROM reports WHERE user_id = $1 ORDER BY created_at DESC;`
+	reports := []Report{}
+	if err := s.db.SelectContext(ctx, &reports, stmt, userID); err != nil {
+		return nil, fmt.Errorf("failed to fetch reports for user %s: %w", userID, err)
+	}
+
+	return reports, nil
+}
+
+// MarkStarted sets started_at on a queued report, counts the attempt and resets the progress of earlier attempts.
+// It returns sql.ErrNoRows if the report does not exist, is already running or is finished.
+func (s *ReportStore) MarkStarted(ctx context.Context, userID, id uuid.UUID) (*Report, error) {
+	const stmt = `UPDATE reports SET started_at = CURRENT_TIMESTAMP, heartbeat_at = CURRENT_TIMESTAMP, attempts = attempts + 1,
+	progress_percent = 0, progress_stage = NULL, rows_processed = 0, progress_updated_at = NULL
+	WHERE user_id = $1 AND id = $2 AND started_at IS NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark report %s as started: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// MarkCompleted sets completed_at, the output location, size and checksum and the download url of a report.
+// Like the other Mark methods it only applies to attempt while it is running, the attempt MarkStarted returned,
+// and returns sql.ErrNoRows if the report was cancelled or another attempt took over, e.g. after it was reaped.
+func (s *ReportStore) MarkCompleted(ctx context.Context, userID, id uuid.UUID, attempt int, output Output, downloadUrl string, downloadUrlExpiresAt time.Time) (*Report, error) {
+	const stmt = `UPDATE reports SET completed_at = CURRENT_TIMESTAMP, output_file_path = $4, output_size = $5, output_checksum = $6,
+	download_url = $7, download_url_expires_at = $8, error_message = NULL, progress_percent = 100, progress_updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 AND attempts = $3
+		AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id, attempt, output.FilePath, output.Size, output.Checksum, downloadUrl, downloadUrlExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark report %s as completed: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// MarkFailed sets failed_at and the error message of a report, it is used for errors that retrying does not fix
+func (s *ReportStore) MarkFailed(ctx context.Context, userID, id uuid.UUID, attempt int, errorMessage string) (*Report, error) {
+	const stmt = `UPDATE reports SET failed_at = CURRENT_TIMESTAMP, error_message = $4
+	WHERE user_id = $1 AND id = $2 AND attempts = $3
+		AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id, attempt, errorMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark report %s as failed: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// MarkAttemptFailed records the error of attempt and puts the report back in the queued state
+func (s *ReportStore) MarkAttemptFailed(ctx context.Context, userID, id uuid.UUID, attempt int, errorMessage string) (*Report, error) {
+	const stmt = `UPDATE reports SET started_at = NULL, heartbeat_at = NULL, error_message = $4::text,
+	attempt_errors = attempt_errors || jsonb_build_array(jsonb_build_object('attempt', attempts, 'error', $4::text, 'failed_at', CURRENT_TIMESTAMP))
+	WHERE user_id = $1 AND id = $2 AND attempts = $3
+		AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id, attempt, errorMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record failed attempt of report %s: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// MarkDeadLettered records the error of the last attempt and moves the report to the dead_lettered state
+func (s *ReportStore) MarkDeadLettered(ctx context.Context, userID, id uuid.UUID, attempt int, errorMessage string) (*Report, error) {
+	const stmt = `UPDATE reports SET failed_at = CURRENT_TIMESTAMP, dead_lettered_at = CURRENT_TIMESTAMP, error_message = $4::text,
+	attempt_errors = attempt_errors || jsonb_build_array(jsonb_build_object('attempt', attempts, 'error', $4::text, 'failed_at', CURRENT_TIMESTAMP))
+	WHERE user_id = $1 AND id = $2 AND attempts = $3
+		AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id, attempt, errorMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dead letter report %s: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// Release puts a running report back in the queued state without counting the attempt, it is used
+// when the worker stops before the report finished. It returns sql.ErrNoRows if attempt is not running.
+func (s *ReportStore) Release(ctx context.Context, userID, id uuid.UUID, attempt int) (*Report, error) {
+	const stmt = `UPDATE reports SET started_at = NULL, heartbeat_at = NULL, attempts = GREATEST(attempts - 1, 0),
+	progress_percent = 0, progress_stage = NULL, rows_processed = 0, progress_updated_at = NULL
+	WHERE user_id = $1 AND id = $2 AND attempts = $3
+		AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id, attempt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release report %s: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// Requeue resets a dead lettered report so it is attempted again, the errors of earlier attempts are kept.
+// The job of the report is added to the outbox in the same transaction.
+// It returns sql.ErrNoRows if the report does not exist or is not dead lettered.
+func (s *ReportStore) Requeue(ctx context.Context, userID, id uuid.UUID) (*Report, error) {
+	const stmt = `UPDATE reports SET attempts = 0, started_at = NULL, failed_at = NULL, dead_lettered_at = NULL, error_message = NULL
+	WHERE user_id = $1 AND id = $2 AND dead_lettered_at IS NOT NULL RETURNING *;`
+	var report Report
+	if err := withTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &report, stmt, userID, id); err != nil {
+			return err
+		}
+		if err := notifyReportEvent(ctx, tx, &report); err != nil {
+			return err
+		}
+		return enqueueReportJob(ctx, tx, &report)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to requeue report %s: %w", id, err)
+	}
+
+	return &report, nil
+}
+
+// Cancel sets cancelled_at on a report that is queued or running. A running report is stopped
+// by its worker on the next heartbeat. It returns sql.ErrNoRows if the report does not exist or is finished.
+func (s *ReportStore) Cancel(ctx context.Context, userID, id uuid.UUID) (*Report, error) {
+	const stmt = `UPDATE reports SET cancelled_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel report %s: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// Heartbeat refreshes heartbeat_at of a running attempt, the returned report has CancelledAt set
+// once the report was cancelled. It returns sql.ErrNoRows if attempt is no longer running,
+// e.g. because it was reaped and another attempt started since.
+func (s *ReportStore) Heartbeat(ctx context.Context, userID, id uuid.UUID, attempt int) (*Report, error) {
+	const stmt = `UPDATE reports SET heartbeat_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 AND attempts = $3 AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL RETURNING *;`
+	var report Report
+	if err := s.db.GetContext(ctx, &report, stmt, userID, id, attempt); err != nil {
+		return nil, fmt.Errorf("failed to heartbeat report %s: %w", id, err)
+	}
+
+	return &report, nil
+}
+
+// UpdateProgress records how far the generation of a running attempt got.
+// It returns sql.ErrNoRows if attempt is no longer running.
+func (s *ReportStore) UpdateProgress(ctx context.Context, userID, id uuid.UUID, attempt int, percent int, stage string, rowsProcessed int64) (*Report, error) {
+	const stmt = `UPDATE reports SET progress_percent = $4, progress_stage = NULLIF($5, ''), rows_processed = $6, progress_updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 AND attempts = $3
+		AND started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL RETURNING *;`
+	report, err := s.updateAndNotify(ctx, stmt, userID, id, attempt, percent, stage, rowsProcessed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update progress of report %s: %w", id, err)
+	}
+
+	return report, nil
+}
+
+// ReapStale takes running reports without a heartbeat for staleAfter away from their worker.
+// Reports with attempts left go back to the queued state and their jobs are added to the outbox,
+// the others are dead lettered. Rows locked by a concurrent reaper are skipped, so every stale
+// report is returned to exactly one caller.
+func (s *ReportStore) ReapStale(ctx context.Context, staleAfter time.Duration, maxAttempts int) ([]Report, error) {
+	const stmt = `WITH stale AS (
+		SELECT user_id, id FROM reports
+		WHERE started_at IS NOT NULL AND completed_at IS NULL AND failed_at IS NULL AND cancelled_at IS NULL
+			AND heartbeat_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE reports r SET
+		started_at = CASE WHEN r.attempts >= $2 THEN r.started_at END,
+		heartbeat_at = NULL,
+		failed_at = CASE WHEN r.attempts >= $2 THEN CURRENT_TIMESTAMP END,
+		dead_lettered_at = CASE WHEN r.attempts >= $2 THEN CURRENT_TIMESTAMP END,
+		error_message = $3::text,
+		attempt_errors = r.attempt_errors || jsonb_build_array(jsonb_build_object('attempt', r.attempts, 'error', $3::text, 'failed_at', CURRENT_TIMESTAMP))
+	FROM stale WHERE r.user_id = stale.user_id AND r.id = stale.id
+	RETURNING r.*;`
+	reports := []Report{}
+	if err := withTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &reports, stmt, staleAfter.Seconds(), maxAttempts, "worker stopped sending heartbeats"); err != nil {
+			return err
+		}
+		for _, report := range reports {
+			if err := notifyReportEvent(ctx, tx, &report); err != nil {
+				return err
+			}
+			if report.DeadLetteredAt != nil {
+				if err := enqueueWebhookDeliveries(ctx, tx, &report); err != nil {
+					return err
+				}
+				if err := settleFollowers(ctx, tx, &report); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := enqueueReportJob(ctx, tx, &report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to reap stale reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// UpdateDownloadUrl replaces the download url of a report
+func (s *ReportStore) UpdateDownloadUrl(ctx context.Context, userID, id uuid.UUID, downloadUrl string, downloadUrlExpiresAt time.Time) (*Report, error) {
+	const stmt = `UPDATE reports SET download_url = $3, download_url_expires_at = $4
+	WHERE user_id = $1 AND id = $2 RETURNING *;`
+	var report Report
+	if err := s.db.GetContext(ctx, &report, stmt, userID, id, downloadUrl, downloadUrlExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to update download url of report %s: %w", id, err)
+	}
+
+	return &report, nil
+}
@@ -0,0 +1,312 @@
+package store_test
+
+import (
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportStore(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+
+	user, err := userStore.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.Create(ctx, user.ID, "sample", json.RawMessage(`{"rows": 10}`), store.CreateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, user.ID, report.UserID)
+	require.Equal(t, "sample", report.ReportType)
+	require.JSONEq(t, `{"rows": 10}`, string(report.Parameters))
+	require.Equal(t, store.ReportStatusQueued, report.Status())
+	require.Nil(t, report.StartedAt)
+
+	report2, err := reportStore.ByPrimaryKey(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	require.Equal(t, report.ID, report2.ID)
+	require.Equal(t, report.ReportType, report2.ReportType)
+	require.Equal(t, report.CreatedAt.UnixNano(), report2.CreatedAt.UnixNano())
+	require.JSONEq(t, string(report.Parameters), string(report2.Parameters))
+
+	_, err = reportStore.ByPrimaryKey(ctx, uuid.New(), report.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	report3, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{}`, string(report3.Parameters))
+
+	reports, err := reportStore.ByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.Equal(t, report.ID, reports[1].ID)
+
+	report, err = reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	require.NotNil(t, report.StartedAt)
+	require.Equal(t, store.ReportStatusRunning, report.Status())
+
+	report, err = reportStore.UpdateProgress(ctx, user.ID, report.ID, report.Attempts, 40, "generating", 400)
+	require.NoError(t, err)
+	require.Equal(t, 40, report.ProgressPercent)
+	require.Equal(t, "generating", *report.ProgressStage)
+	require.Equal(t, int64(400), report.RowsProcessed)
+	require.NotNil(t, report.ProgressUpdatedAt)
+
+	expiresAt := time.Now().Add(time.Hour)
+	output := store.Output{FilePath: "reports/output.csv", Size: 1024, Checksum: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+	report, err = reportStore.MarkCompleted(ctx, user.ID, report.ID, report.Attempts, output, "http://download/1", expiresAt)
+	require.NoError(t, err)
+	require.NotNil(t, report.CompletedAt)
+	require.Equal(t, "reports/output.csv", *report.OutputFilePath)
+	require.Equal(t, int64(1024), *report.OutputSize)
+	require.Equal(t, output.Checksum, *report.OutputChecksum)
+	require.Equal(t, "http://download/1", *report.DownloadUrl)
+	require.Equal(t, expiresAt.UnixMilli(), report.DownloadUrlExpiresAt.UnixMilli())
+	require.Equal(t, store.ReportStatusCompleted, report.Status())
+	require.Equal(t, 100, report.ProgressPercent)
+
+	_, err = reportStore.UpdateProgress(ctx, user.ID, report.ID, report.Attempts, 50, "generating", 500)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	expiresAt = time.Now().Add(2 * time.Hour)
+	report, err = reportStore.UpdateDownloadUrl(ctx, user.ID, report.ID, "http://download/2", expiresAt)
+	require.NoError(t, err)
+	require.Equal(t, "http://download/2", *report.DownloadUrl)
+	require.Equal(t, expiresAt.UnixMilli(), report.DownloadUrlExpiresAt.UnixMilli())
+
+	_, err = reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	report, err = reportStore.MarkStarted(ctx, user.ID, reports[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Attempts)
+	report, err = reportStore.MarkFailed(ctx, user.ID, report.ID, report.Attempts, "boom")
+	require.NoError(t, err)
+	require.NotNil(t, report.FailedAt)
+	require.Equal(t, "boom", *report.ErrorMessage)
+	require.Equal(t, store.ReportStatusFailed, report.Status())
+}
+
+func TestReportStoreRetries(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+
+	user, err := userStore.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Attempts)
+	require.Empty(t, report.AttemptErrors)
+
+	report, err = reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	report, err = reportStore.MarkAttemptFailed(ctx, user.ID, report.ID, report.Attempts, "first")
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusQueued, report.Status())
+	require.Equal(t, 1, report.Attempts)
+	require.Len(t, report.AttemptErrors, 1)
+	require.Equal(t, 1, report.AttemptErrors[0].Attempt)
+	require.Equal(t, "first", report.AttemptErrors[0].Error)
+
+	// an attempt that is not running anymore can not fail the report again
+	_, err = reportStore.MarkAttemptFailed(ctx, user.ID, report.ID, 1, "first again")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = reportStore.Requeue(ctx, user.ID, report.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	report, err = reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Attempts)
+	_, err = reportStore.MarkDeadLettered(ctx, user.ID, report.ID, 1, "stale")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	report, err = reportStore.MarkDeadLettered(ctx, user.ID, report.ID, report.Attempts, "second")
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusDeadLettered, report.Status())
+	require.NotNil(t, report.FailedAt)
+	require.Equal(t, "second", *report.ErrorMessage)
+	require.Len(t, report.AttemptErrors, 2)
+	require.Equal(t, 2, report.AttemptErrors[1].Attempt)
+	_, err = reportStore.MarkAttemptFailed(ctx, user.ID, report.ID, report.Attempts, "after dead letter")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	report, err = reportStore.Requeue(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusQueued, report.Status())
+	require.Equal(t, 0, report.Attempts)
+	require.Nil(t, report.ErrorMessage)
+	require.Len(t, report.AttemptErrors, 2)
+}
+
+func TestReportStoreReapStale(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+
+	user, err := userStore.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	queued, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	_, err = reportStore.Heartbeat(ctx, user.ID, queued.ID, queued.Attempts)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	running, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	running, err = reportStore.MarkStarted(ctx, user.ID, running.ID)
+	require.NoError(t, err)
+	require.NotNil(t, running.HeartbeatAt)
+	_, err = reportStore.MarkStarted(ctx, user.ID, running.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	heartbeat, err := reportStore.Heartbeat(ctx, user.ID, running.ID, 1)
+	require.NoError(t, err)
+	require.True(t, heartbeat.HeartbeatAt.After(*running.HeartbeatAt) || heartbeat.HeartbeatAt.Equal(*running.HeartbeatAt))
+
+	reaped, err := reportStore.ReapStale(ctx, time.Minute, 2)
+	require.NoError(t, err)
+	require.Empty(t, reaped)
+
+	reaped, err = reportStore.ReapStale(ctx, -time.Minute, 2)
+	require.NoError(t, err)
+	require.Len(t, reaped, 1)
+	require.Equal(t, running.ID, reaped[0].ID)
+	require.Equal(t, store.ReportStatusQueued, reaped[0].Status())
+	require.Len(t, reaped[0].AttemptErrors, 1)
+
+	_, err = reportStore.Heartbeat(ctx, user.ID, running.ID, 1)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// the reaped attempt can not touch the report once another attempt started it
+	restarted, err := reportStore.MarkStarted(ctx, user.ID, running.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, restarted.Attempts)
+	_, err = reportStore.Heartbeat(ctx, user.ID, running.ID, 1)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.UpdateProgress(ctx, user.ID, running.ID, 1, 50, "rows", 500)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.MarkCompleted(ctx, user.ID, running.ID, 1, store.Output{FilePath: "reports/output.csv"}, "http://download", time.Now())
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.MarkFailed(ctx, user.ID, running.ID, 1, "boom")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.Release(ctx, user.ID, running.ID, 1)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.Heartbeat(ctx, user.ID, running.ID, restarted.Attempts)
+	require.NoError(t, err)
+
+	reaped, err = reportStore.ReapStale(ctx, -time.Minute, 2)
+	require.NoError(t, err)
+	require.Len(t, reaped, 1)
+	require.Equal(t, store.ReportStatusDeadLettered, reaped[0].Status())
+	require.Len(t, reaped[0].AttemptErrors, 2)
+}
+
+func TestReportStoreRelease(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+
+	user, err := userStore.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	report, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	_, err = reportStore.Release(ctx, user.ID, report.ID, 0)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	_, err = reportStore.UpdateProgress(ctx, user.ID, report.ID, 1, 40, "rows", 400)
+	require.NoError(t, err)
+
+	released, err := reportStore.Release(ctx, user.ID, report.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusQueued, released.Status())
+	require.Equal(t, 0, released.Attempts)
+	require.Equal(t, 0, released.ProgressPercent)
+	require.Nil(t, released.HeartbeatAt)
+
+	// the released report can be started again
+	started, err := reportStore.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, started.Attempts)
+}
+
+func TestReportStoreCancel(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	reportStore := store.NewReportStore(env.DB)
+	userStore := store.NewUserStore(env.DB)
+
+	user, err := userStore.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	queued, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	queued, err = reportStore.Cancel(ctx, user.ID, queued.ID)
+	require.NoError(t, err)
+	require.Equal(t, store.ReportStatusCancelled, queued.Status())
+	_, err = reportStore.MarkStarted(ctx, user.ID, queued.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.Cancel(ctx, user.ID, queued.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	running, err := reportStore.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	_, err = reportStore.MarkStarted(ctx, user.ID, running.ID)
+	require.NoError(t, err)
+	_, err = reportStore.Cancel(ctx, user.ID, running.ID)
+	require.NoError(t, err)
+
+	// the worker learns about the cancellation from its heartbeat
+	heartbeat, err := reportStore.Heartbeat(ctx, user.ID, running.ID, 1)
+	require.NoError(t, err)
+	require.NotNil(t, heartbeat.CancelledAt)
+
+	_, err = reportStore.MarkCompleted(ctx, user.ID, running.ID, 1, store.Output{FilePath: "reports/output.csv"}, "http://download", time.Now())
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = reportStore.MarkAttemptFailed(ctx, user.ID, running.ID, 1, "boom")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
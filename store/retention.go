@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionPolicy is how long something is kept per report type. Report types without
+// an entry in ByReportType keep it for Default, a zero duration keeps it forever.
+type RetentionPolicy struct {
+	Default      time.Duration
+	ByReportType map[string]time.Duration
+}
+
+// args returns the policy as the report types and seconds arrays and the default seconds used by the queries
+func (p RetentionPolicy) args() ([]string, []float64, float64) {
+	reportTypes := make([]string, 0, len(p.ByReportType))
+	seconds := make([]float64, 0, len(p.ByReportType))
+	for reportType, ttl := range p.ByReportType {
+		reportTypes = append(reportTypes, reportType)
+		seconds = append(seconds, ttl.Seconds())
+	}
+	return reportTypes, seconds, p.Default.Seconds()
+}
+
+// ExpiredOutputs returns up to limit completed reports whose output is older than the policy allows
+func (s *ReportStore) ExpiredOutputs(ctx context.Context, policy RetentionPolicy, limit int) ([]Report, error) {
+	const stmt = `SELECT r.* FROM reports r
+	LEFT JOIN unnest($1::text[], $2::float8[]) AS p(report_type, ttl) ON p.report_type = r.report_type
+	WHERE r.output_file_path IS NOT NULL AND r.completed_at IS NOT NULL AND COALESCE(p.ttl, $3) > 0
+		AND r.completed_at < CURRENT_TIMESTAMP - make_interval(secs => COALESCE(p.ttl, $3))
+	ORDER BY r.completed_at
+	LIMIT $4;`
+	reportTypes, seconds, defaultSeconds := policy.args()
+	reports := []Report{}
+	if err := s.db.SelectContext(ctx, &reports, stmt, reportTypes, seconds, defaultSeconds, limit); err != nil {
+		return nil, fmt.Errorf("failed to fetch reports with expired outputs: %w", err)
+	}
+
+	return reports, nil
+}
+
+// ClearOutput forgets the output of a report once it was deleted from the blob store
+func (s *ReportStore) ClearOutput(ctx context.Context, userID, id uuid.UUID) (*Report, error) {
+	const stmt = `UPDATE reports SET output_file_path = NULL, download_url = NULL, download_url_expires_at = NULL, output_expired_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 RETURNING *;`
+	var report Report
+	if err := s.db.GetContext(ctx, &report, stmt, userID, id); err != nil {
+		return nil, fmt.Errorf("failed to clear output of report %s: %w", id, err)
+	}
+
+	return &report, nil
+}
+
+// DeleteExpired deletes up to limit finished reports older than the policy allows, or moves them to
+// reports_archive if archive is set. Reports that still have an output are kept until it is cleared,
+// so no object is left behind in the blob store. It returns the number of removed reports.
+func (s *ReportStore) DeleteExpired(ctx context.Context, policy RetentionPolicy, limit int, archive bool) (int, error) {
+	const expired = `WITH expired AS (
+		SELECT r.user_id, r.id FROM reports r
+		LEFT JOIN unnest($1::text[], $2::float8[]) AS p(report_type, ttl) ON p.report_type = r.report_type
+		WHERE r.output_file_path IS NULL AND COALESCE(p.ttl, $3) > 0
+			AND COALESCE(r.completed_at, r.failed_at, r.cancelled_at) < CURRENT_TIMESTAMP - make_interval(secs => COALESCE(p.ttl, $3))
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	),
+	deleted AS (
+		DELETE FROM reports r USING expired e WHERE r.user_id = e.user_id AND r.id = e.id RETURNING r.*
+	)`
+	stmt := expired + ` SELECT count(*) FROM deleted;`
+	if archive {
+		stmt = expired + `, archived AS (
+			INSERT INTO reports_archive (user_id, id, report_type, report) SELECT d.user_id, d.id, d.report_type, to_jsonb(d) FROM deleted d
+			ON CONFLICT DO NOTHING RETURNING 1
+		)
+		SELECT count(*) FROM deleted;`
+	}
+
+	reportTypes, seconds, defaultSeconds := policy.args()
+	var count int
+	if err := s.db.GetContext(ctx, &count, stmt, reportTypes, seconds, defaultSeconds, limit); err != nil {
+		return 0, fmt.Errorf("failed to delete expired reports: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type ScheduleStore struct {
+	db *sqlx.DB
+}
+
+func NewScheduleStore(db *sql.DB) *ScheduleStore {
+	return &ScheduleStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+// ReportSchedule creates a report of ReportType with Parameters on every run of CronExpression
+type ReportSchedule struct {
+	ID             uuid.UUID       `db:"id"`
+	UserID         uuid.UUID       `db:"user_id"`
+	ReportType     string          `db:"report_type"`
+	Parameters     json.RawMessage `db:"parameters"`
+	CronExpression string          `db:"cron_expression"`
+	Timezone       string          `db:"timezone"`
+	Enabled        bool            `db:"enabled"`
+	NextRunAt      time.Time       `db:"next_run_at"`
+	LastRunAt      *time.Time      `db:"last_run_at"`
+	LastReportID   *uuid.UUID      `db:"last_report_id"`
+	CreatedAt      time.Time       `db:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at"`
+}
+
+// ScheduleParams are the fields of a schedule set by its owner. NextRunAt is computed
+// by the caller from the cron expression, the store does not interpret it.
+type ScheduleParams struct {
+	ReportType     string
+	Parameters     json.RawMessage
+	CronExpression string
+	Timezone       string
+	Enabled        bool
+	NextRunAt      time.Time
+}
+
+// ScheduledRuns are the runs of a due schedule and when it is due next
+type ScheduledRuns struct {
+	Runs      []time.Time
+	NextRunAt time.Time
+	// Disable turns the schedule off, e.g. because its cron expression never matches again
+	Disable bool
+}
+
+// SkippedRun is a run of a schedule that created no report because the owner exceeded their quota
+type SkippedRun struct {
+	ScheduleID uuid.UUID
+	UserID     uuid.UUID
+	RunAt      time.Time
+	Err        error
+}
+
+// Create inserts a schedule of the user, parameters must be a JSON object or empty
+func (s *ScheduleStore) Create(ctx context.Context, userID uuid.UUID, params ScheduleParams) (*ReportSchedule, error) {
+	const stmt = `INSERT INTO report_schedules (user_id, report_type, parameters, cron_expression, timezone, enabled, next_run_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *;`
+	if len(params.Parameters) == 0 {
+		params.Parameters = json.RawMessage(`{}`)
+	}
+	var schedule ReportSchedule
+	if err := s.db.GetContext(ctx, &schedule, stmt, userID, params.ReportType, params.Parameters, params.CronExpression, params.Timezone, params.Enabled, params.NextRunAt); err != nil {
+		return nil, fmt.Errorf("failed to create report schedule: %w", err)
+	}
+
+	return &schedule, nil
+}
+
+// ByPrimaryKey extracts the schedule by userID and schedule id
+func (s *ScheduleStore) ByPrimaryKey(ctx context.Context, userID, id uuid.UUID) (*ReportSchedule, error) {
+	const stmt = `SELECT * FROM report_schedules WHERE user_id = $1 AND id = $2;`
+	var schedule ReportSchedule
+	if err := s.db.GetContext(ctx, &schedule, stmt, userID, id); err != nil {
+		return nil, fmt.Errorf("failed to fetch report schedule %s for user %s: %w", id, userID, err)
+	}
+
+	return &schedule, nil
+}
+
+// ByUserID extracts all schedules of the user, oldest first
+func (s *ScheduleStore) ByUserID(ctx context.Context, userID uuid.UUID) ([]ReportSchedule, error) {
+	const stmt = `SELECT * FROM report_schedules WHERE user_id = $1 ORDER BY created_at;`
+	schedules := []ReportSchedule{}
+	if err := s.db.SelectContext(ctx, &schedules, stmt, userID); err != nil {
+		return nil, fmt.Errorf("failed to fetch report schedules for user %s: %w", userID, err)
+	}
+
+	return schedules, nil
+}
+
+// Update replaces the fields of a schedule set by its owner
+func (s *ScheduleStore) Update(ctx context.Context, userID, id uuid.UUID, params ScheduleParams) (*ReportSchedule, error) {
+	const stmt = `UPDATE report_schedules SET report_type = $3, parameters = $4, cron_expression = $5, timezone = $6, enabled = $7,
+	next_run_at = $8, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 RETURNING *;`
+	if len(params.Parameters) == 0 {
+		params.Parameters = json.RawMessage(`{}`)
+	}
+	var schedule ReportSchedule
+	if err := s.db.GetContext(ctx, &schedule, stmt, userID, id, params.ReportType, params.Parameters, params.CronExpression, params.Timezone, params.Enabled, params.NextRunAt); err != nil {
+		return nil, fmt.Errorf("failed to update report schedule %s: %w", id, err)
+	}
+
+	return &schedule, nil
+}
+
+// Delete removes a schedule, reports it created are kept. It returns sql.ErrNoRows if the schedule does not exist.
+func (s *ScheduleStore) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	const stmt = `DELETE FROM report_schedules WHERE user_id = $1 AND id = $2 RETURNING id;`
+	var deleted uuid.UUID
+	if err := s.db.GetContext(ctx, &deleted, stmt, userID, id); err != nil {
+		return fmt.Errorf("failed to delete report schedule %s: %w", id, err)
+	}
+	return nil
+}
+
+// RunDue takes up to limit enabled schedules due at now and asks plan for their runs. A report is created
+// for every run, in the same transaction as the schedule moves on to the next run, so a run is never created
+// twice. A schedule plan fails for is disabled, so it does not hold up the others. Runs that would exceed the
+// quota of the schedule owner are skipped. The reports get the normal priority, or the owner's max priority if
+// that is lower. Rows locked by a concurrent scheduler are skipped. It returns the created reports and the
+// skipped runs.
+func (s *ScheduleStore) RunDue(ctx context.Context, now time.Time, limit int, quota ReportQuota, plan func(schedule ReportSchedule) (ScheduledRuns, error)) ([]Report, []SkippedRun, error) {
+	const (
+		selectStmt      = `SELECT * FROM report_schedules WHERE enabled AND next_run_at <= $1 ORDER BY next_run_at LIMIT $2 FOR UPDATE SKIP LOCKED;`
+		maxPriorityStmt = `SELECT max_priority FROM users WHERE id = $1;`
+		updateStmt      = `UPDATE report_schedules SET next_run_at = $2, last_run_at = COALESCE($3, last_run_at), last_report_id = COALESCE($4, last_report_id),
+		enabled = enabled AND NOT $5::boolean, updated_at = CASE WHEN $5::boolean THEN CURRENT_TIMESTAMP ELSE updated_at END
+		WHERE id = $1;`
+	)
+	reports := []Report{}
+	skipped := []SkippedRun{}
+	if err := withTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		schedules := []ReportSchedule{}
+		if err := tx.SelectContext(ctx, &schedules, selectStmt, now, limit); err != nil {
+			return err
+		}
+
+		for _, schedule := range schedules {
+			runs, err := plan(schedule)
+			if err != nil {
+				runs = ScheduledRuns{NextRunAt: schedule.NextRunAt, Disable: true}
+			}
+
+			var (
+				lastRunAt    *time.Time
+				lastReportID *uuid.UUID
+				priority     = PriorityNormal
+			)
+			if len(runs.Runs) > 0 {
+				var maxPriority string
+				if err := tx.GetContext(ctx, &maxPriority, maxPriorityStmt, schedule.UserID); err != nil {
+					return err
+				}
+				priority = ClampPriority(priority, maxPriority)
+			}
+			for _, run := range runs.Runs {
+				// checkQuota fails before it changes anything, the transaction stays usable
+				if err := checkQuota(ctx, tx, schedule.UserID, quota); err != nil {
+					if !errors.Is(err, ErrQuotaExceeded) {
+						return err
+					}
+					skipped = append(skipped, SkippedRun{ScheduleID: schedule.ID, UserID: schedule.UserID, RunAt: run, Err: err})
+					continue
+				}
+				report, err := createReport(ctx, tx, schedule.UserID, schedule.ReportType, schedule.Parameters, priority)
+				if err != nil {
+					return err
+				}
+				reports = append(reports, *report)
+				lastRunAt, lastReportID = &run, &report.ID
+			}
+			if _, err := tx.ExecContext(ctx, updateStmt, schedule.ID, runs.NextRunAt, lastRunAt, lastReportID, runs.Disable); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to run due report schedules: %w", err)
+	}
+
+	return reports, skipped, nil
+}
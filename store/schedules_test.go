@@ -0,0 +1,184 @@
+package store_test
+
+import (
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleStore(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	now := time.Now()
+	schedule, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		Parameters:     json.RawMessage(`{"rows": 10}`),
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		Enabled:        true,
+		NextRunAt:      now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"rows": 10}`, string(schedule.Parameters))
+	require.Nil(t, schedule.LastRunAt)
+
+	disabled, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		NextRunAt:      now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	require.False(t, disabled.Enabled)
+
+	schedules, err := dataStore.Schedules.ByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, schedules, 2)
+
+	next := now.Add(time.Hour)
+	reports, _, err := dataStore.Schedules.RunDue(ctx, now, 10, store.ReportQuota{}, func(due store.ReportSchedule) (store.ScheduledRuns, error) {
+		require.Equal(t, schedule.ID, due.ID)
+		return store.ScheduledRuns{Runs: []time.Time{due.NextRunAt, due.NextRunAt}, NextRunAt: next}, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.Equal(t, "sample", reports[0].ReportType)
+	require.JSONEq(t, `{"rows": 10}`, string(reports[0].Parameters))
+	require.Equal(t, store.ReportStatusQueued, reports[0].Status())
+
+	schedule, err = dataStore.Schedules.ByPrimaryKey(ctx, user.ID, schedule.ID)
+	require.NoError(t, err)
+	require.Equal(t, next.UnixMilli(), schedule.NextRunAt.UnixMilli())
+	require.NotNil(t, schedule.LastRunAt)
+	require.Equal(t, reports[1].ID, *schedule.LastReportID)
+
+	// a disabled schedule is not due anymore
+	never, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "0 0 30 2 *",
+		Timezone:       "UTC",
+		Enabled:        true,
+		NextRunAt:      now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	reports, _, err = dataStore.Schedules.RunDue(ctx, now, 10, store.ReportQuota{}, func(due store.ReportSchedule) (store.ScheduledRuns, error) {
+		require.Equal(t, never.ID, due.ID)
+		return store.ScheduledRuns{NextRunAt: due.NextRunAt, Disable: true}, nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, reports)
+	never, err = dataStore.Schedules.ByPrimaryKey(ctx, user.ID, never.ID)
+	require.NoError(t, err)
+	require.False(t, never.Enabled)
+
+	// a schedule that can not be planned is disabled, the others still run
+	broken, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		Enabled:        true,
+		NextRunAt:      now.Add(-2 * time.Minute),
+	})
+	require.NoError(t, err)
+	healthy, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		Enabled:        true,
+		NextRunAt:      now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	reports, _, err = dataStore.Schedules.RunDue(ctx, now, 10, store.ReportQuota{}, func(due store.ReportSchedule) (store.ScheduledRuns, error) {
+		if due.ID == broken.ID {
+			return store.ScheduledRuns{}, errors.New("invalid timezone")
+		}
+		return store.ScheduledRuns{Runs: []time.Time{due.NextRunAt}, NextRunAt: next}, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	broken, err = dataStore.Schedules.ByPrimaryKey(ctx, user.ID, broken.ID)
+	require.NoError(t, err)
+	require.False(t, broken.Enabled)
+	require.NoError(t, dataStore.Schedules.Delete(ctx, user.ID, healthy.ID))
+
+	// runs over the quota of the owner are skipped, the schedule still moves on
+	limited, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		Enabled:        true,
+		NextRunAt:      now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	// three reports of the earlier runs are still active, one more fits
+	quota := store.ReportQuota{MaxActive: 4}
+	reports, skipped, err := dataStore.Schedules.RunDue(ctx, now, 10, quota, func(due store.ReportSchedule) (store.ScheduledRuns, error) {
+		return store.ScheduledRuns{Runs: []time.Time{due.NextRunAt, due.NextRunAt.Add(time.Second)}, NextRunAt: next}, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Len(t, skipped, 1)
+	require.Equal(t, limited.ID, skipped[0].ScheduleID)
+	require.ErrorIs(t, skipped[0].Err, store.ErrQuotaExceeded)
+	limited, err = dataStore.Schedules.ByPrimaryKey(ctx, user.ID, limited.ID)
+	require.NoError(t, err)
+	require.Equal(t, next.UnixMilli(), limited.NextRunAt.UnixMilli())
+	require.Equal(t, reports[0].ID, *limited.LastReportID)
+	require.NoError(t, dataStore.Schedules.Delete(ctx, user.ID, limited.ID))
+
+	// scheduled reports do not exceed the highest priority the owner may use
+	_, err = dataStore.Users.SetMaxPriority(ctx, user.ID, store.PriorityLow)
+	require.NoError(t, err)
+	lowered, err := dataStore.Schedules.Create(ctx, user.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "0 * * * *",
+		Timezone:       "UTC",
+		Enabled:        true,
+		NextRunAt:      now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	reports, _, err = dataStore.Schedules.RunDue(ctx, now, 10, store.ReportQuota{}, func(due store.ReportSchedule) (store.ScheduledRuns, error) {
+		return store.ScheduledRuns{Runs: []time.Time{due.NextRunAt}, NextRunAt: next}, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Equal(t, store.PriorityLow, reports[0].Priority)
+	require.NoError(t, dataStore.Schedules.Delete(ctx, user.ID, lowered.ID))
+
+	reports, _, err = dataStore.Schedules.RunDue(ctx, now, 10, store.ReportQuota{}, func(due store.ReportSchedule) (store.ScheduledRuns, error) {
+		t.Fatal("no schedule is due")
+		return store.ScheduledRuns{}, nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, reports)
+
+	schedule, err = dataStore.Schedules.Update(ctx, user.ID, schedule.ID, store.ScheduleParams{
+		ReportType:     "sample",
+		CronExpression: "30 7 * * *",
+		Timezone:       "Europe/Berlin",
+		NextRunAt:      next,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "30 7 * * *", schedule.CronExpression)
+	require.False(t, schedule.Enabled)
+
+	require.NoError(t, dataStore.Schedules.Delete(ctx, user.ID, schedule.ID))
+	require.ErrorIs(t, dataStore.Schedules.Delete(ctx, user.ID, schedule.ID), sql.ErrNoRows)
+}
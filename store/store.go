@@ -5,11 +5,21 @@ import "database/sql"
 type Store struct {
 	Users             *UserStore
 	RefreshTokenStore *RefreshTokenStore
+	Reports           *ReportStore
+	Outbox            *OutboxStore
+	Webhooks          *WebhookStore
+	Schedules         *ScheduleStore
+	Idempotency       *IdempotencyStore
 }
 
 func New(db *sql.DB) *Store {
 	return &Store{
 		Users:             NewUserStore(db),
 		RefreshTokenStore: NewRefreshTokenStore(db),
+		Reports:           NewReportStore(db),
+		Outbox:            NewOutboxStore(db),
+		Webhooks:          NewWebhookStore(db),
+		Schedules:         NewScheduleStore(db),
+		Idempotency:       NewIdempotencyStore(db),
 	}
 }
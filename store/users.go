@@ -29,6 +29,9 @@ type User struct {
 	Email                string    `db:"email"`
 	HashedPasswordBase64 string    `db:"hashed_password"` // Base64
 	CreatedAt            time.Time `db:"created_at"`
+	// MaxPriority is the highest priority of the reports the user may create
+	MaxPriority string `db:"max_priority"`
+	IsAdmin     bool   `db:"is_admin"`
 }
 
 func (u *User) ComparePassword(password string) error {
@@ -83,3 +86,15 @@ func (s *UserStore) ByID(ctx context.Context, userID uuid.UUID) (*User, error) {
 
 	return &user, nil
 }
+
+// SetMaxPriority changes the highest priority of the reports the user may create
+func (s *UserStore) SetMaxPriority(ctx context.Context, userID uuid.UUID, maxPriority string) (*User, error) {
+	const stmt = `UPDATE users SET max_priority = $2 WHERE id = $1 RETURNING *`
+	var user User
+
+	if err := s.db.GetContext(ctx, &user, stmt, userID, maxPriority); err != nil {
+		return nil, fmt.Errorf("failed to set max priority of user %s: %w", userID, err)
+	}
+
+	return &user, nil
+}
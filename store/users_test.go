@@ -40,4 +40,10 @@ func TestUserStore(t *testing.T) {
 	require.Equal(t, user.ID, user2.ID)
 	require.Equal(t, user.HashedPasswordBase64, user2.HashedPasswordBase64)
 	require.Equal(t, user.CreatedAt.UnixNano(), user2.CreatedAt.UnixNano())
+
+	require.Equal(t, store.PriorityNormal, user.MaxPriority)
+	require.False(t, user.IsAdmin)
+	user2, err = userStore.SetMaxPriority(ctx, user.ID, store.PriorityHigh)
+	require.NoError(t, err)
+	require.Equal(t, store.PriorityHigh, user2.MaxPriority)
 }
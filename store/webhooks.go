@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	WebhookEventReportCompleted = "report.completed"
+	WebhookEventReportFailed    = "report.failed"
+)
+
+type WebhookStore struct {
+	db *sqlx.DB
+}
+
+func NewWebhookStore(db *sql.DB) *WebhookStore {
+	return &WebhookStore{
+		db: sqlx.NewDb(db, "postgres"),
+	}
+}
+
+type Webhook struct {
+	ID                      uuid.UUID  `db:"id"`
+	UserID                  uuid.UUID  `db:"user_id"`
+	Url                     string     `db:"url"`
+	Secret                  string     `db:"secret"`
+	PreviousSecret          *string    `db:"previous_secret"`
+	PreviousSecretExpiresAt *time.Time `db:"previous_secret_expires_at"`
+	CreatedAt               time.Time  `db:"created_at"`
+	UpdatedAt               time.Time  `db:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one webhook, it records the outcome of the last attempt
+type WebhookDelivery struct {
+	ID             int64           `db:"id"`
+	WebhookID      uuid.UUID       `db:"webhook_id"`
+	ReportID       uuid.UUID       `db:"report_id"`
+	Event          string          `db:"event"`
+	Payload        json.RawMessage `db:"payload"`
+	Attempts       int             `db:"attempts"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at"`
+	LastStatusCode *int            `db:"last_status_code"`
+	LastError      *string         `db:"last_error"`
+	DeliveredAt    *time.Time      `db:"delivered_at"`
+	FailedAt       *time.Time      `db:"failed_at"`
+	CreatedAt      time.Time       `db:"created_at"`
+}
+
+// ClaimedDelivery is a delivery due for an attempt together with where and how to send it
+type ClaimedDelivery struct {
+	WebhookDelivery
+	Url    string `db:"url"`
+	Secret string `db:"secret"`
+	// PreviousSecret is set while the previous secret of a rotation is still valid
+	PreviousSecret *string `db:"previous_secret"`
+}
+
+// WebhookPayload is the JSON body sent to webhooks
+type WebhookPayload struct {
+	Event                string     `json:"event"`
+	UserID               uuid.UUID  `json:"user_id"`
+	ReportID             uuid.UUID  `json:"report_id"`
+	ReportType           string     `json:"report_type"`
+	Status               string     `json:"status"`
+	DownloadUrl          *string    `json:"download_url,omitempty"`
+	DownloadUrlExpiresAt *time.Time `json:"download_url_expires_at,omitempty"`
+	ErrorMessage         *string    `json:"error_message,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	CompletedAt          *time.Time `json:"completed_at,omitempty"`
+	FailedAt             *time.Time `json:"failed_at,omitempty"`
+}
+
+// newWebhookSecret returns a random secret, the prefix makes it recognizable in configuration files
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Create registers a webhook of the user with a newly generated secret
+func (s *WebhookStore) Create(ctx context.Context, userID uuid.UUID, url string) (*Webhook, error) {
+	const stmt = `INSERT INTO webhooks (user_id, url, secret) VALUES ($1, $2, $3) RETURNING *;`
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	var webhook Webhook
+	if err := s.db.GetContext(ctx, &webhook, stmt, userID, url, secret); err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+// ByPrimaryKey extracts the webhook by userID and webhook id
+func (s *WebhookStore) ByPrimaryKey(ctx context.Context, userID, id uuid.UUID) (*Webhook, error) {
+	const stmt = `SELECT * FROM webhooks WHERE user_id = $1 AND id = $2;`
+	var webhook Webhook
+	if err := s.db.GetContext(ctx, &webhook, stmt, userID, id); err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook %s for user %s: %w", id, userID, err)
+	}
+
+	return &webhook, nil
+}
+
+// ByUserID extracts all webhooks of the user, oldest first
+func (s *WebhookStore) ByUserID(ctx context.Context, userID uuid.UUID) ([]Webhook, error) {
+	const stmt = `SELECT * FROM webhooks WHERE user_id = $1 ORDER BY created_at;`
+	webhooks := []Webhook{}
+	if err := s.db.SelectContext(ctx, &webhooks, stmt, userID); err != nil {
+		return nil, fmt.Errorf("failed to fetch webhooks for user %s: %w", userID, err)
+	}
+
+	return webhooks, nil
+}
+
+// UpdateUrl changes the url of a webhook, pending deliveries are sent to the new url
+func (s *WebhookStore) UpdateUrl(ctx context.Context, userID, id uuid.UUID, url string) (*Webhook, error) {
+	const stmt = `UPDATE webhooks SET url = $3, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id = $2 RETURNING *;`
+	var webhook Webhook
+	if err := s.db.GetContext(ctx, &webhook, stmt, userID, id, url); err != nil {
+		return nil, fmt.Errorf("failed to update webhook %s: %w", id, err)
+	}
+
+	return &webhook, nil
+}
+
+// RotateSecret replaces the secret of a webhook. The old secret keeps signing the payloads
+// next to the new one for the grace period, so receivers can switch without dropping deliveries.
+func (s *WebhookStore) RotateSecret(ctx context.Context, userID, id uuid.UUID, grace time.Duration) (*Webhook, error) {
+	const stmt = `UPDATE webhooks SET previous_secret = secret, previous_secret_expires_at = CURRENT_TIMESTAMP + make_interval(secs => $4),
+	secret = $3, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND id = $2 RETURNING *;`
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	var webhook Webhook
+	if err := s.db.GetContext(ctx, &webhook, stmt, userID, id, secret, grace.Seconds()); err != nil {
+		return nil, fmt.Errorf("failed to rotate secret of webhook %s: %w", id, err)
+	}
+
+	return &webhook, nil
+}
+
+// Delete removes a webhook and its deliveries. It returns sql.ErrNoRows if the webhook does not exist.
+func (s *WebhookStore) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	const stmt = `DELETE FROM webhooks WHERE user_id = $1 AND id = $2 RETURNING id;`
+	var deleted uuid.UUID
+	if err := s.db.GetContext(ctx, &deleted, stmt, userID, id); err != nil {
+		return fmt.Errorf("failed to delete webhook %s: %w", id, err)
+	}
+	return nil
+}
+
+// Deliveries extracts the latest deliveries of a webhook of the user, newest first
+func (s *WebhookStore) Deliveries(ctx context.Context, userID, webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	const stmt = `SELECT d.* FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE w.user_id = $1 AND w.id = $2 ORDER BY d.created_at DESC, d.id DESC LIMIT $3;`
+	deliveries := []WebhookDelivery{}
+	if err := s.db.SelectContext(ctx, &deliveries, stmt, userID, webhookID, limit); err != nil {
+		return nil, fmt.Errorf("failed to fetch deliveries of webhook %s: %w", webhookID, err)
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDue takes up to limit deliveries due for an attempt and counts the attempt. A claimed delivery
+// is not due again before lease, so a dispatcher that dies mid attempt does not lose it. Rows locked
+// by a concurrent dispatcher are skipped.
+func (s *WebhookStore) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]ClaimedDelivery, error) {
+	const stmt = `WITH due AS (
+		SELECT id FROM webhook_deliveries
+		WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE webhook_deliveries d SET attempts = d.attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+	FROM due, webhooks w WHERE d.id = due.id AND w.id = d.webhook_id
+	RETURNING d.*, w.url, w.secret, CASE WHEN w.previous_secret_expires_at > CURRENT_TIMESTAMP THEN w.previous_secret END AS previous_secret;`
+	deliveries := []ClaimedDelivery{}
+	if err := s.db.SelectContext(ctx, &deliveries, stmt, limit, lease.Seconds()); err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// MarkDelivered records a successful attempt of a delivery
+func (s *WebhookStore) MarkDelivered(ctx context.Context, id int64, statusCode int) error {
+	const stmt = `UPDATE webhook_deliveries SET delivered_at = CURRENT_TIMESTAMP, last_status_code = $2, last_error = NULL WHERE id = $1;`
+	if _, err := s.db.ExecContext(ctx, stmt, id, statusCode); err != nil {
+		return fmt.Errorf("failed to mark webhook delivery %d as delivered: %w", id, err)
+	}
+	return nil
+}
+
+// MarkAttemptFailed records a failed attempt of a delivery, which is due again after delay.
+// A statusCode of 0 means no response was received.
+func (s *WebhookStore) MarkAttemptFailed(ctx context.Context, id int64, statusCode int, errorMessage string, delay time.Duration) error {
+	const stmt = `UPDATE webhook_deliveries SET last_status_code = NULLIF($2, 0), last_error = $3,
+	next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $4) WHERE id = $1;`
+	if _, err := s.db.ExecContext(ctx, stmt, id, statusCode, errorMessage, delay.Seconds()); err != nil {
+		return fmt.Errorf("failed to record failed attempt of webhook delivery %d: %w", id, err)
+	}
+	return nil
+}
+
+// MarkFailed records the last failed attempt of a delivery, it is not attempted again
+func (s *WebhookStore) MarkFailed(ctx context.Context, id int64, statusCode int, errorMessage string) error {
+	const stmt = `UPDATE webhook_deliveries SET failed_at = CURRENT_TIMESTAMP, last_status_code = NULLIF($2, 0), last_error = $3 WHERE id = $1;`
+	if _, err := s.db.ExecContext(ctx, stmt, id, statusCode, errorMessage); err != nil {
+		return fmt.Errorf("failed to mark webhook delivery %d as failed: %w", id, err)
+	}
+	return nil
+}
+
+// enqueueWebhookDeliveries adds a delivery for every webhook of the user when the report completed
+// or failed for good, it must run in the transaction changing the report
+func enqueueWebhookDeliveries(ctx context.Context, tx sqlx.ExecerContext, report *Report) error {
+	var event string
+	switch report.Status() {
+	case ReportStatusCompleted:
+		event = WebhookEventReportCompleted
+	case ReportStatusFailed, ReportStatusDeadLettered:
+		event = WebhookEventReportFailed
+	default:
+		return nil
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event:                event,
+		UserID:               report.UserID,
+		ReportID:             report.ID,
+		ReportType:           report.ReportType,
+		Status:               report.Status(),
+		DownloadUrl:          report.DownloadUrl,
+		DownloadUrlExpiresAt: report.DownloadUrlExpiresAt,
+		ErrorMessage:         report.ErrorMessage,
+		CreatedAt:            report.CreatedAt,
+		CompletedAt:          report.CompletedAt,
+		FailedAt:             report.FailedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	const stmt = `INSERT INTO webhook_deliveries (webhook_id, report_id, event, payload)
+	SELECT id, $2::uuid, $3::text, $4::jsonb FROM webhooks WHERE user_id = $1;`
+	if _, err := tx.ExecContext(ctx, stmt, report.UserID, report.ID, event, string(payload)); err != nil {
+		return fmt.Errorf("failed to insert webhook deliveries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package store_test
+
+import (
+	"async_api/fixture"
+	"async_api/store"
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookStore(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	webhook, err := dataStore.Webhooks.Create(ctx, user.ID, "http://localhost/hook")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/hook", webhook.Url)
+	require.NotEmpty(t, webhook.Secret)
+	require.Nil(t, webhook.PreviousSecret)
+
+	webhook, err = dataStore.Webhooks.UpdateUrl(ctx, user.ID, webhook.ID, "http://localhost/other")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/other", webhook.Url)
+
+	secret := webhook.Secret
+	webhook, err = dataStore.Webhooks.RotateSecret(ctx, user.ID, webhook.ID, time.Hour)
+	require.NoError(t, err)
+	require.NotEqual(t, secret, webhook.Secret)
+	require.Equal(t, secret, *webhook.PreviousSecret)
+	require.True(t, webhook.PreviousSecretExpiresAt.After(time.Now()))
+
+	webhooks, err := dataStore.Webhooks.ByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+
+	// only finished reports are delivered
+	report, err := dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	_, err = dataStore.Reports.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	_, err = dataStore.Reports.MarkFailed(ctx, user.ID, report.ID, 1, "boom")
+	require.NoError(t, err)
+
+	claimed, err := dataStore.Webhooks.ClaimDue(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, store.WebhookEventReportFailed, claimed[0].Event)
+	require.Equal(t, report.ID, claimed[0].ReportID)
+	require.Equal(t, webhook.Secret, claimed[0].Secret)
+	require.Equal(t, secret, *claimed[0].PreviousSecret)
+	require.Equal(t, 1, claimed[0].Attempts)
+
+	// claimed deliveries are not due before their lease expires
+	again, err := dataStore.Webhooks.ClaimDue(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, again)
+
+	require.NoError(t, dataStore.Webhooks.MarkAttemptFailed(ctx, claimed[0].ID, 500, "unexpected response status", -time.Second))
+	claimed, err = dataStore.Webhooks.ClaimDue(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, 2, claimed[0].Attempts)
+	require.NoError(t, dataStore.Webhooks.MarkFailed(ctx, claimed[0].ID, 0, "connection refused"))
+
+	deliveries, err := dataStore.Webhooks.Deliveries(ctx, user.ID, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.NotNil(t, deliveries[0].FailedAt)
+	require.Nil(t, deliveries[0].LastStatusCode)
+	require.Equal(t, "connection refused", *deliveries[0].LastError)
+
+	_, err = dataStore.Webhooks.ByPrimaryKey(ctx, uuid.New(), webhook.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	require.NoError(t, dataStore.Webhooks.Delete(ctx, user.ID, webhook.ID))
+	require.ErrorIs(t, dataStore.Webhooks.Delete(ctx, user.ID, webhook.ID), sql.ErrNoRows)
+}
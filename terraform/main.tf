@@ -62,3 +62,13 @@ resource "aws_sqs_queue" "terraform_queue" {
   message_retention_seconds = 86400
   receive_wait_time_seconds = 10
 }
+
+# lanes of the high and low priority report jobs, the queue above is the normal lane
+resource "aws_sqs_queue" "terraform_queue_lanes" {
+  for_each                  = toset(["high", "low"])
+  name                      = "${var.sqs_queue}-${each.key}"
+  delay_seconds             = 90
+  max_message_size          = 2048
+  message_retention_seconds = 86400
+  receive_wait_time_seconds = 10
+}
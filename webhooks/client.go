@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for webhook urls that point to the loopback, private, link-local
+// (including cloud metadata endpoints) or otherwise internal addresses
+var ErrForbiddenAddress = errors.New("webhook address is not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range, it is internal like the private ranges
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func forbiddenAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// CheckHost rejects webhook hosts that are internal addresses or localhost. Names are only checked
+// against the resolved address when a delivery connects, so a name pointing to an internal address
+// is rejected then.
+func CheckHost(host string) error {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	if ip, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil && forbiddenAddress(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// newClient returns the client of the deliveries. Unless allowPrivateNetworks is set it refuses to connect
+// to internal addresses, which is checked on the resolved address so DNS can not be used to get around it.
+// Redirects are not followed, the redirect response is the result of the delivery.
+func newClient(timeout time.Duration, allowPrivateNetworks bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+			}
+			if forbiddenAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect to the address on our behalf, past the check of the dialer
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
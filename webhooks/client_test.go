@@ -0,0 +1,46 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForbiddenAddress(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		require.True(t, forbiddenAddress(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"93.184.216.34", "8.8.8.8", "2606:4700::1111"} {
+		require.False(t, forbiddenAddress(netip.MustParseAddr(addr)), addr)
+	}
+
+	require.ErrorIs(t, CheckHost("localhost"), ErrForbiddenAddress)
+	require.ErrorIs(t, CheckHost("169.254.169.254"), ErrForbiddenAddress)
+	require.ErrorIs(t, CheckHost("[::1]"), ErrForbiddenAddress)
+	require.NoError(t, CheckHost("example.com"))
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the test server listens on loopback
+	_, err := newClient(time.Second, false).Get(server.URL)
+	require.ErrorIs(t, err, ErrForbiddenAddress)
+
+	client := newClient(time.Second, true)
+	resp, err := client.Get(server.URL + "/redirect")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+}
@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"async_api/config"
+	"async_api/events"
+	"async_api/retry"
+	"async_api/store"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of deliveries claimed, and sent in parallel, at a time
+	dispatchBatchSize = 20
+	// Response bodies are read up to this size so the connection can be reused
+	maxResponseBodySize = 64 << 10
+)
+
+// Dispatcher sends the pending webhook deliveries. Failed attempts are retried with backoff until
+// WebhookMaxAttempts, then the delivery is marked as failed. Several dispatchers can run at the same time.
+type Dispatcher struct {
+	config *config.Config
+	logger *slog.Logger
+	store  *store.Store
+	events *events.Listener
+	client *http.Client
+	retry  retry.Policy
+}
+
+// NewDispatcher returns a dispatcher that is woken up by the report events of listener.
+// Without a listener deliveries are only picked up every WebhookDispatchInterval.
+func NewDispatcher(config *config.Config, logger *slog.Logger, store *store.Store, listener *events.Listener) *Dispatcher {
+	return &Dispatcher{
+		config: config,
+		logger: logger,
+		store:  store,
+		events: listener,
+		client: newClient(config.WebhookTimeout, config.WebhookAllowPrivateNetworks),
+		retry: retry.Policy{
+			MaxAttempts: config.WebhookMaxAttempts,
+			BaseDelay:   config.WebhookRetryBaseDelay,
+			MaxDelay:    config.WebhookRetryMaxDelay,
+		},
+	}
+}
+
+// Start sends pending deliveries until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context) error {
+	d.logger.Info("starting webhook dispatcher", "interval", d.config.WebhookDispatchInterval)
+	ticker := time.NewTicker(d.config.WebhookDispatchInterval)
+	defer ticker.Stop()
+
+	var finished <-chan store.ReportEvent
+	if d.events != nil {
+		sub := d.events.Subscribe(func(event store.ReportEvent) bool {
+			switch event.Status {
+			case store.ReportStatusCompleted, store.ReportStatusFailed, store.ReportStatusDeadLettered:
+				return true
+			}
+			return false
+		})
+		defer sub.Close()
+		finished = sub.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-finished:
+		}
+		d.dispatch(ctx)
+	}
+}
+
+// dispatch sends batches of due deliveries until none is left
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	for {
+		// a claimed delivery is due again if its attempt did not finish within twice the request timeout
+		deliveries, err := d.store.Webhooks.ClaimDue(ctx, dispatchBatchSize, 2*d.config.WebhookTimeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Error("failed to claim webhook deliveries", "error", err)
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < dispatchBatchSize {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt of a delivery and records its outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery store.ClaimedDelivery) {
+	logger := d.logger.With("delivery_id", delivery.ID, "webhook_id", delivery.WebhookID, "report_id", delivery.ReportID, "attempt", delivery.Attempts)
+
+	statusCode, sendErr := d.send(ctx, delivery)
+	var err error
+	switch {
+	case sendErr == nil:
+		logger.Info("webhook delivered", "status_code", statusCode)
+		err = d.store.Webhooks.MarkDelivered(ctx, delivery.ID, statusCode)
+	case d.retry.Exhausted(delivery.Attempts):
+		logger.Error("webhook delivery failed on its last attempt", "error", sendErr, "status_code", statusCode)
+		err = d.store.Webhooks.MarkFailed(ctx, delivery.ID, statusCode, sendErr.Error())
+	default:
+		delay := d.retry.Backoff(delivery.Attempts)
+		logger.Warn("webhook delivery attempt failed, retrying", "error", sendErr, "status_code", statusCode, "delay", delay)
+		err = d.store.Webhooks.MarkAttemptFailed(ctx, delivery.ID, statusCode, sendErr.Error(), delay)
+	}
+	if err != nil && ctx.Err() == nil {
+		// the delivery is attempted again once its claim expires
+		logger.Error("failed to record webhook delivery attempt", "error", err)
+	}
+}
+
+// send posts the signed payload, it returns the status code of the response or 0 if there was none
+func (d *Dispatcher) send(ctx context.Context, delivery store.ClaimedDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	secrets := []string{delivery.Secret}
+	if delivery.PreviousSecret != nil {
+		secrets = append(secrets, *delivery.PreviousSecret)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "async_api-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set(SignatureHeader, Sign(delivery.Payload, time.Now(), secrets...))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBodySize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
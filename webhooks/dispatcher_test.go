@@ -0,0 +1,109 @@
+package webhooks_test
+
+import (
+	"async_api/config"
+	"async_api/fixture"
+	"async_api/store"
+	"async_api/webhooks"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcher(t *testing.T) {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataStore := store.New(env.DB)
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	var (
+		failures atomic.Int32
+		received = make(chan store.WebhookPayload, 1)
+		secret   string
+	)
+	failures.Store(1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler runs outside of the test goroutine, so it uses assert instead of require
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, webhooks.Verify(r.Header.Get(webhooks.SignatureHeader), body, secret, time.Minute, time.Now()))
+		assert.Equal(t, store.WebhookEventReportCompleted, r.Header.Get("X-Webhook-Event"))
+
+		// the first attempt fails so the delivery is retried
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload store.WebhookPayload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	webhook, err := dataStore.Webhooks.Create(ctx, user.ID, receiver.URL)
+	require.NoError(t, err)
+	// deliveries made during the grace period verify with either secret
+	webhook, err = dataStore.Webhooks.RotateSecret(ctx, user.ID, webhook.ID, time.Hour)
+	require.NoError(t, err)
+	secret = *webhook.PreviousSecret
+
+	report, err := dataStore.Reports.Create(ctx, user.ID, "sample", nil, store.CreateOptions{})
+	require.NoError(t, err)
+	_, err = dataStore.Reports.MarkStarted(ctx, user.ID, report.ID)
+	require.NoError(t, err)
+	_, err = dataStore.Reports.MarkCompleted(ctx, user.ID, report.ID, 1, store.Output{FilePath: "reports/output.csv"}, "http://download", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	conf := &config.Config{
+		// the receiver listens on loopback
+		WebhookAllowPrivateNetworks: true,
+		WebhookDispatchInterval:     10 * time.Millisecond,
+		WebhookMaxAttempts:          3,
+		WebhookRetryBaseDelay:       10 * time.Millisecond,
+		WebhookRetryMaxDelay:        10 * time.Millisecond,
+		WebhookTimeout:              time.Second,
+	}
+	dispatcher := webhooks.NewDispatcher(conf, slog.New(slog.NewTextHandler(os.Stdout, nil)), dataStore, nil)
+	go dispatcher.Start(ctx)
+
+	select {
+	case payload := <-received:
+		require.Equal(t, store.WebhookEventReportCompleted, payload.Event)
+		require.Equal(t, report.ID, payload.ReportID)
+		require.Equal(t, store.ReportStatusCompleted, payload.Status)
+		require.Equal(t, "http://download", *payload.DownloadUrl)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	require.Eventually(t, func() bool {
+		deliveries, err := dataStore.Webhooks.Deliveries(ctx, user.ID, webhook.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		return deliveries[0].DeliveredAt != nil
+	}, time.Second, 10*time.Millisecond)
+
+	deliveries, err := dataStore.Webhooks.Deliveries(ctx, user.ID, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, deliveries[0].Attempts)
+	require.Equal(t, http.StatusOK, *deliveries[0].LastStatusCode)
+	require.Nil(t, deliveries[0].LastError)
+}
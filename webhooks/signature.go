@@ -0,0 +1,69 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the signatures of a webhook payload as "t=<unix time>,v1=<signature>".
+// Each v1 signature is the hex encoded HMAC-SHA256 of "<unix time>.<body>". During a secret
+// rotation there is one v1 signature per valid secret.
+const SignatureHeader = "X-Webhook-Signature"
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+func signature(body []byte, timestamp int64, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the SignatureHeader value of body signed at timestamp with every secret
+func Sign(body []byte, timestamp time.Time, secrets ...string) string {
+	unix := timestamp.Unix()
+	parts := []string{"t=" + strconv.FormatInt(unix, 10)}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+signature(body, unix, secret))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Verify checks that header has a signature of body made with secret no longer than tolerance before now.
+// Receivers use it to authenticate deliveries.
+func Verify(header string, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	var (
+		timestamp  int64
+		signatures []string
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = t
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || now.Sub(time.Unix(timestamp, 0)).Abs() > tolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(body, timestamp, secret)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
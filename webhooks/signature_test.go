@@ -0,0 +1,22 @@
+package webhooks_test
+
+import (
+	"async_api/webhooks"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"event":"report.completed"}`)
+	now := time.Now()
+
+	header := webhooks.Sign(body, now, "new", "old")
+	require.NoError(t, webhooks.Verify(header, body, "new", time.Minute, now))
+	require.NoError(t, webhooks.Verify(header, body, "old", time.Minute, now))
+	require.ErrorIs(t, webhooks.Verify(header, body, "other", time.Minute, now), webhooks.ErrInvalidSignature)
+	require.ErrorIs(t, webhooks.Verify(header, []byte(`{}`), "new", time.Minute, now), webhooks.ErrInvalidSignature)
+	require.ErrorIs(t, webhooks.Verify(header, body, "new", time.Minute, now.Add(2*time.Minute)), webhooks.ErrInvalidSignature)
+	require.ErrorIs(t, webhooks.Verify("v1=abc", body, "new", time.Minute, now), webhooks.ErrInvalidSignature)
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"async_api/reports"
+	"async_api/store"
+	"context"
+	"sync"
+	"time"
+)
+
+// progressTracker keeps the latest progress reported by a generator until it is persisted,
+// so a generator reporting every row does not cause a write per row
+type progressTracker struct {
+	mu     sync.Mutex
+	latest reports.Progress
+	dirty  bool
+}
+
+// update is the reports.ProgressFunc handed to the generator
+func (t *progressTracker) update(progress reports.Progress) {
+	progress.Percent = min(max(progress.Percent, 0), 100)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.latest = progress
+	t.dirty = true
+}
+
+// take returns the latest progress if it changed since the last call
+func (t *progressTracker) take() (reports.Progress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return reports.Progress{}, false
+	}
+	t.dirty = false
+	return t.latest, true
+}
+
+// persistProgress writes the progress of the report at most once per interval until ctx is done
+func (w *Worker) persistProgress(ctx context.Context, tracker *progressTracker, report *store.Report, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			progress, ok := tracker.take()
+			if !ok {
+				continue
+			}
+			// sql.ErrNoRows means the attempt is no longer running, the heartbeat takes care of that
+			if _, err := w.store.Reports.UpdateProgress(ctx, report.UserID, report.ID, report.Attempts, progress.Percent, progress.Stage, progress.RowsProcessed); err != nil && ctx.Err() == nil {
+				w.logger.Debug("failed to update report progress", "error", err, "report_id", report.ID)
+			}
+		}
+	}
+}
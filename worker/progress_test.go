@@ -0,0 +1,32 @@
+package worker
+
+import (
+	"async_api/reports"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressTracker(t *testing.T) {
+	var tracker progressTracker
+	_, ok := tracker.take()
+	require.False(t, ok)
+
+	tracker.update(reports.Progress{Percent: 10, Stage: "querying"})
+	tracker.update(reports.Progress{Percent: 20, Stage: "writing", RowsProcessed: 200})
+	progress, ok := tracker.take()
+	require.True(t, ok)
+	require.Equal(t, reports.Progress{Percent: 20, Stage: "writing", RowsProcessed: 200}, progress)
+
+	_, ok = tracker.take()
+	require.False(t, ok)
+
+	tracker.update(reports.Progress{Percent: 150})
+	progress, ok = tracker.take()
+	require.True(t, ok)
+	require.Equal(t, 100, progress.Percent)
+
+	tracker.update(reports.Progress{Percent: -5})
+	progress, _ = tracker.take()
+	require.Equal(t, 0, progress.Percent)
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"async_api/config"
+	"async_api/store"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Reaper finds running reports whose worker stopped sending heartbeats and queues them again
+// through the outbox. Several reapers can run at the same time, each stale report is picked up
+// by only one of them.
+type Reaper struct {
+	config *config.Config
+	logger *slog.Logger
+	store  *store.Store
+}
+
+func NewReaper(config *config.Config, logger *slog.Logger, store *store.Store) *Reaper {
+	return &Reaper{
+		config: config,
+		logger: logger,
+		store:  store,
+	}
+}
+
+// Start reaps stale reports every ReaperInterval until ctx is cancelled
+func (r *Reaper) Start(ctx context.Context) error {
+	r.logger.Info("starting report reaper", "interval", r.config.ReaperInterval)
+	ticker := time.NewTicker(r.config.ReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	reports, err := r.store.Reports.ReapStale(ctx, r.config.WorkerHeartbeatTimeout, r.config.ReportMaxAttempts)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Error("failed to reap stale reports", "error", err)
+		}
+		return
+	}
+
+	for _, report := range reports {
+		logger := r.logger.With("report_id", report.ID, "user_id", report.UserID, "attempt", report.Attempts)
+		if report.DeadLetteredAt != nil {
+			logger.Warn("reaped stale report on its last attempt, dead lettered")
+			continue
+		}
+		logger.Warn("reaped stale report, queued it again")
+	}
+}
@@ -0,0 +1,21 @@
+package worker
+
+import "errors"
+
+// permanentError marks failures that retrying does not fix, like an unknown report type
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
@@ -0,0 +1,16 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermanentError(t *testing.T) {
+	err := fmt.Errorf("generate: %w", &permanentError{err: errors.New("unknown report type")})
+	require.True(t, isPermanent(err))
+	require.Equal(t, "generate: unknown report type", err.Error())
+	require.False(t, isPermanent(errors.New("connection reset")))
+}
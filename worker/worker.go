@@ -0,0 +1,368 @@
+package worker
+
+import (
+	"async_api/blobstore"
+	"async_api/config"
+	"async_api/queue"
+	"async_api/reports"
+	"async_api/retry"
+	"async_api/store"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// Delay before polling the queue again after a receive error
+	receiveErrorDelay = 5 * time.Second
+	// Time to record the outcome of a job once its generation returned, it is not cut short by the drain
+	settleTimeout = 10 * time.Second
+	// Buffer between the generator and the upload, so small writes of the generator do not each wait for the upload
+	uploadBufferSize = 64 << 10
+)
+
+var (
+	// errReportCancelled is the cause of a job context cancelled because the user cancelled the report
+	errReportCancelled = errors.New("report was cancelled")
+	// errReportReaped is the cause of a job context cancelled because the attempt lost the report,
+	// e.g. because the reaper queued it again and another worker started it since
+	errReportReaped = errors.New("report was reaped")
+	// errJobTimedOut is the cause of a job context cancelled because the generation exceeded the job timeout
+	errJobTimedOut = errors.New("report generation timed out")
+	// errWorkerDraining is the cause of a job context cancelled because the worker stopped before the job finished
+	errWorkerDraining = errors.New("worker is shutting down")
+)
+
+type Worker struct {
+	config    *config.Config
+	logger    *slog.Logger
+	store     *store.Store
+	consumer  queue.Consumer
+	blobStore blobstore.Store
+	registry  *reports.Registry
+	retry     retry.Policy
+	// concurrency is the number of jobs processed in parallel
+	concurrency int
+}
+
+func New(config *config.Config, logger *slog.Logger, store *store.Store, consumer queue.Consumer, blobStore blobstore.Store, registry *reports.Registry) *Worker {
+	return &Worker{
+		config:    config,
+		logger:    logger,
+		store:     store,
+		consumer:  consumer,
+		blobStore: blobStore,
+		registry:  registry,
+		retry: retry.Policy{
+			MaxAttempts: config.ReportMaxAttempts,
+			BaseDelay:   config.ReportRetryBaseDelay,
+			MaxDelay:    config.ReportRetryMaxDelay,
+		},
+		concurrency: max(config.WorkerConcurrency, 1),
+	}
+}
+
+// Start consumes report jobs with up to concurrency jobs in parallel until ctx is cancelled.
+// It then stops receiving and waits up to the drain timeout for the running jobs, jobs still
+// running after that are released back to the queue.
+func (w *Worker) Start(ctx context.Context) error {
+	w.logger.Info("starting report worker", "concurrency", w.concurrency)
+
+	// jobs are not cancelled with ctx, so they can finish while the worker drains
+	jobsCtx, cancelJobs := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelJobs(nil)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, w.concurrency)
+	for {
+		select {
+		case <-ctx.Done():
+			w.drain(&wg, cancelJobs)
+			return nil
+		case slots <- struct{}{}:
+		}
+
+		msgs, err := w.consumer.Receive(ctx, 1)
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				w.drain(&wg, cancelJobs)
+				return nil
+			}
+			w.logger.Error("failed to receive report jobs", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(receiveErrorDelay):
+			}
+			continue
+		}
+		if len(msgs) == 0 {
+			<-slots
+			continue
+		}
+
+		msg := msgs[0]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := w.process(jobsCtx, msg); err != nil {
+				// leave the message in the queue, it is redelivered after its visibility timeout
+				w.logger.Error("failed to process report job", "error", err, "message_id", msg.ID, "report_id", msg.Job.ReportID)
+			}
+		}()
+	}
+}
+
+// drain waits for the running jobs up to the drain timeout, then cancels the remaining ones
+// so they release their reports, and waits for them to return
+func (w *Worker) drain(wg *sync.WaitGroup, cancelJobs context.CancelCauseFunc) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	w.logger.Info("draining report worker", "timeout", w.config.WorkerDrainTimeout)
+	select {
+	case <-done:
+		w.logger.Info("report worker drained")
+		return
+	case <-time.After(w.config.WorkerDrainTimeout):
+	}
+
+	w.logger.Warn("drain timeout reached, releasing running reports")
+	cancelJobs(errWorkerDraining)
+	<-done
+}
+
+// process generates the report of a job and acknowledges the message. An error means
+// the message is left in the queue, failures of the generation itself are recorded on the report row.
+func (w *Worker) process(ctx context.Context, msg *queue.Message) error {
+	job := msg.Job
+	logger := w.logger.With("report_id", job.ReportID, "user_id", job.UserID)
+
+	report, err := w.store.Reports.MarkStarted(ctx, job.UserID, job.ReportID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Warn("report not found, already running or finished, skipping job")
+			return w.consumer.Ack(ctx, msg)
+		}
+		return err
+	}
+	logger.Info("processing report", "report_type", report.ReportType, "attempt", report.Attempts)
+
+	timeoutCtx, cancelTimeout := context.WithTimeoutCause(ctx, w.config.WorkerJobTimeout, errJobTimedOut)
+	defer cancelTimeout()
+	jobCtx, cancel := context.WithCancelCause(timeoutCtx)
+	defer cancel(nil)
+	go w.heartbeat(jobCtx, cancel, report)
+	go w.keepVisible(jobCtx, msg)
+
+	var tracker progressTracker
+	go w.persistProgress(jobCtx, &tracker, report, w.config.WorkerProgressInterval)
+
+	output, err := w.generate(jobCtx, report, tracker.update)
+
+	// the outcome is recorded even if the worker is draining meanwhile
+	ctx, cancelSettle := context.WithTimeout(context.WithoutCancel(ctx), settleTimeout)
+	defer cancelSettle()
+
+	if err != nil {
+		switch context.Cause(jobCtx) {
+		case errReportCancelled:
+			logger.Info("report was cancelled while processing, dropping job")
+			return w.consumer.Ack(ctx, msg)
+		case errReportReaped:
+			logger.Warn("report was reaped while processing, dropping job")
+			return w.consumer.Ack(ctx, msg)
+		case errWorkerDraining:
+			return w.release(ctx, msg, report)
+		case errJobTimedOut:
+			return w.fail(ctx, msg, report, fmt.Errorf("report generation timed out after %s", w.config.WorkerJobTimeout))
+		}
+		return w.fail(ctx, msg, report, err)
+	}
+
+	downloadUrlExpiresAt := time.Now().Add(w.config.DownloadUrlLifetime)
+	downloadUrl, err := w.blobStore.PresignGet(ctx, output.FilePath, w.config.DownloadUrlLifetime)
+	if err != nil {
+		return w.fail(ctx, msg, report, err)
+	}
+
+	if _, err := w.store.Reports.MarkCompleted(ctx, job.UserID, job.ReportID, report.Attempts, output, downloadUrl, downloadUrlExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Warn("report was cancelled or taken over while processing, dropping job", "attempt", report.Attempts)
+			return w.consumer.Ack(ctx, msg)
+		}
+		return err
+	}
+	logger.Info("report completed", "output_file_path", output.FilePath, "output_size", output.Size)
+	return w.consumer.Ack(ctx, msg)
+}
+
+// release puts a report the worker could not finish before shutting down back in the queue,
+// the interrupted attempt does not count against its attempts
+func (w *Worker) release(ctx context.Context, msg *queue.Message, report *store.Report) error {
+	logger := w.logger.With("report_id", report.ID, "user_id", report.UserID)
+
+	if _, err := w.store.Reports.Release(ctx, report.UserID, report.ID, report.Attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the report was cancelled or reaped meanwhile, the job is done either way
+			return w.consumer.Ack(ctx, msg)
+		}
+		return err
+	}
+	logger.Info("worker is shutting down, released report back to the queue")
+	return w.consumer.Nack(ctx, msg, 0)
+}
+
+// keepVisible extends the visibility of the message until ctx is done, so a job running longer than
+// the visibility timeout is not received by another worker. The queues hide received messages for
+// QueueVisibilityTimeout too, so extending every third of it leaves room for a slow or failed call.
+func (w *Worker) keepVisible(ctx context.Context, msg *queue.Message) {
+	ticker := time.NewTicker(w.config.QueueVisibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.consumer.ExtendVisibility(ctx, msg, w.config.QueueVisibilityTimeout); err != nil && ctx.Err() == nil {
+				w.logger.Error("failed to extend visibility of report job", "error", err, "message_id", msg.ID, "report_id", msg.Job.ReportID)
+			}
+		}
+	}
+}
+
+// heartbeat keeps the report marked as alive until ctx is done. It cancels the job when the
+// report was cancelled by the user or is no longer running, e.g. because the reaper considered it stale.
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, report *store.Report) {
+	ticker := time.NewTicker(w.config.WorkerHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := w.store.Reports.Heartbeat(ctx, report.UserID, report.ID, report.Attempts)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					cancel(errReportReaped)
+					return
+				}
+				if ctx.Err() == nil {
+					w.logger.Error("failed to heartbeat report", "error", err, "report_id", report.ID)
+				}
+				continue
+			}
+			if current.CancelledAt != nil {
+				cancel(errReportCancelled)
+				return
+			}
+		}
+	}
+}
+
+// fail records a failed attempt. The report is retried with backoff until the attempts run out,
+// then it is dead lettered. Permanent errors fail the report right away.
+func (w *Worker) fail(ctx context.Context, msg *queue.Message, report *store.Report, cause error) error {
+	logger := w.logger.With("report_id", report.ID, "user_id", report.UserID, "attempt", report.Attempts)
+
+	var err error
+	switch {
+	case isPermanent(cause):
+		logger.Error("report failed", "error", cause)
+		_, err = w.store.Reports.MarkFailed(ctx, report.UserID, report.ID, report.Attempts, cause.Error())
+	case w.retry.Exhausted(report.Attempts):
+		logger.Error("report failed on its last attempt, dead lettering", "error", cause)
+		_, err = w.store.Reports.MarkDeadLettered(ctx, report.UserID, report.ID, report.Attempts, cause.Error())
+	default:
+		delay := w.retry.Backoff(report.Attempts)
+		logger.Warn("report attempt failed, retrying", "error", cause, "delay", delay)
+		if _, err = w.store.Reports.MarkAttemptFailed(ctx, report.UserID, report.ID, report.Attempts, cause.Error()); err == nil {
+			return w.consumer.Nack(ctx, msg, delay)
+		}
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err != nil {
+		// the report was cancelled or another attempt took it over meanwhile, the job is done either way
+		logger.Warn("report was cancelled or taken over while processing, dropping job")
+	}
+	return w.consumer.Ack(ctx, msg)
+}
+
+// generate streams the output of the generator to the blob store through a pipe, so the output is
+// never held in memory as a whole, and returns where it was stored with its size and checksum
+func (w *Worker) generate(ctx context.Context, report *store.Report, progress reports.ProgressFunc) (store.Output, error) {
+	generator, ok := w.registry.Lookup(report.ReportType)
+	if !ok {
+		return store.Output{}, &permanentError{err: fmt.Errorf("unknown report type %q", report.ReportType)}
+	}
+
+	params, err := reports.DecodeParams(generator, report.Parameters)
+	if err != nil {
+		return store.Output{}, &permanentError{err: err}
+	}
+
+	pr, pw := io.Pipe()
+	out := &outputWriter{w: pw, hash: sha256.New()}
+	generated := make(chan error, 1)
+	go func() {
+		buffered := bufio.NewWriterSize(out, uploadBufferSize)
+		err := generator.Generate(ctx, buffered, report, params, progress)
+		if err == nil {
+			err = buffered.Flush()
+		}
+		// the upload fails with the error of the generator, or completes at EOF
+		pw.CloseWithError(err)
+		generated <- err
+	}()
+
+	outputFilePath := path.Join("reports", report.UserID.String(), report.ID.String()+generator.Extension())
+	if err = w.blobStore.Put(ctx, outputFilePath, pr); err != nil {
+		err = fmt.Errorf("failed to upload report output: %w", err)
+	}
+	// a generator still writing after the upload stopped gets the upload error
+	pr.CloseWithError(err)
+	if generateErr := <-generated; generateErr != nil {
+		return store.Output{}, generateErr
+	}
+	if err != nil {
+		return store.Output{}, err
+	}
+
+	return store.Output{
+		FilePath: outputFilePath,
+		Size:     out.size,
+		Checksum: hex.EncodeToString(out.hash.Sum(nil)),
+	}, nil
+}
+
+// outputWriter passes the output of a generator on to w and computes its size and checksum on the way
+type outputWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	o.hash.Write(p[:n])
+	o.size += int64(n)
+	return n, err
+}
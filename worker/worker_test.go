@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"async_api/blobstore"
+	"async_api/config"
+	"async_api/fixture"
+	"async_api/queue"
+	"async_api/reports"
+	"async_api/store"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type blockingParams struct{}
+
+func (p *blockingParams) Validate() error {
+	return nil
+}
+
+// blockingGenerator runs until finish is closed or its context is done
+type blockingGenerator struct {
+	finish  chan struct{}
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (g *blockingGenerator) Name() string                { return "blocking" }
+func (g *blockingGenerator) Schema() []reports.ParamSpec { return nil }
+func (g *blockingGenerator) NewParams() reports.Params   { return &blockingParams{} }
+func (g *blockingGenerator) Extension() string           { return ".txt" }
+
+func (g *blockingGenerator) Generate(ctx context.Context, w io.Writer, report *store.Report, params reports.Params, progress reports.ProgressFunc) error {
+	running := g.running.Add(1)
+	defer g.running.Add(-1)
+	for peak := g.peak.Load(); running > peak && !g.peak.CompareAndSwap(peak, running); peak = g.peak.Load() {
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-g.finish:
+		_, err := io.WriteString(w, "done")
+		return err
+	}
+}
+
+type workerTest struct {
+	dataStore *store.Store
+	queue     *queue.MemoryQueue
+	generator *blockingGenerator
+	worker    *Worker
+	reports   []*store.Report
+}
+
+func newWorkerTest(t *testing.T, conf *config.Config, jobs int) *workerTest {
+	env := fixture.NewTestEnv(t)
+	cleanup := env.SetupDB(t)
+	t.Cleanup(func() {
+		cleanup(t)
+	})
+
+	ctx := context.Background()
+	dataStore := store.New(env.DB)
+	blobStore, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost", []byte("secret"))
+	require.NoError(t, err)
+
+	generator := &blockingGenerator{finish: make(chan struct{})}
+	registry := reports.NewRegistry()
+	registry.MustRegister(generator)
+
+	user, err := dataStore.Users.CreateUser(ctx, "test@test.com", "testingpassword")
+	require.NoError(t, err)
+
+	reportQueue := queue.NewMemoryQueue()
+	created := make([]*store.Report, 0, jobs)
+	for range jobs {
+		report, err := dataStore.Reports.Create(ctx, user.ID, "blocking", nil, store.CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, reportQueue.Publish(ctx, queue.ReportJob{UserID: user.ID, ReportID: report.ID}))
+		created = append(created, report)
+	}
+
+	return &workerTest{
+		dataStore: dataStore,
+		queue:     reportQueue,
+		generator: generator,
+		worker:    New(conf, slog.New(slog.NewTextHandler(os.Stdout, nil)), dataStore, reportQueue, blobStore, registry),
+		reports:   created,
+	}
+}
+
+func testWorkerConfig(drainTimeout time.Duration) *config.Config {
+	return &config.Config{
+		DownloadUrlLifetime:     time.Hour,
+		QueueVisibilityTimeout:  time.Minute,
+		ReportMaxAttempts:       3,
+		WorkerConcurrency:       2,
+		WorkerDrainTimeout:      drainTimeout,
+		WorkerHeartbeatInterval: time.Minute,
+		WorkerJobTimeout:        time.Minute,
+		WorkerProgressInterval:  time.Minute,
+	}
+}
+
+func TestWorkerDrainsRunningJobs(t *testing.T) {
+	wt := newWorkerTest(t, testWorkerConfig(time.Minute), 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- wt.worker.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return wt.generator.running.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, wt.queue.Len())
+
+	// the running jobs finish within the drain timeout, the queued one is not received anymore
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(wt.generator.finish)
+	require.NoError(t, <-done)
+
+	require.Equal(t, int32(2), wt.generator.peak.Load())
+	require.Equal(t, 1, wt.queue.Len())
+
+	completed := 0
+	for _, report := range wt.reports {
+		current, err := wt.dataStore.Reports.ByPrimaryKey(context.Background(), report.UserID, report.ID)
+		require.NoError(t, err)
+		if current.Status() == store.ReportStatusCompleted {
+			completed++
+		}
+	}
+	require.Equal(t, 2, completed)
+}
+
+func TestWorkerReleasesJobsAfterDrainTimeout(t *testing.T) {
+	wt := newWorkerTest(t, testWorkerConfig(100*time.Millisecond), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- wt.worker.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return wt.generator.running.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not stop after the drain timeout")
+	}
+
+	// both jobs are back in the queue and their reports queued without a counted attempt
+	require.Eventually(t, func() bool {
+		return wt.queue.Len() == 2
+	}, time.Second, 10*time.Millisecond)
+	for _, report := range wt.reports {
+		current, err := wt.dataStore.Reports.ByPrimaryKey(context.Background(), report.UserID, report.ID)
+		require.NoError(t, err)
+		assert.Equal(t, store.ReportStatusQueued, current.Status())
+		assert.Equal(t, 0, current.Attempts)
+	}
+}
+
+func TestWorkerSkipsDuplicateJobs(t *testing.T) {
+	wt := newWorkerTest(t, testWorkerConfig(time.Minute), 1)
+	report := wt.reports[0]
+	// backends like SQS deliver a job published twice twice
+	require.NoError(t, wt.queue.Publish(context.Background(), queue.ReportJob{UserID: report.UserID, ReportID: report.ID}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- wt.worker.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return wt.generator.running.Load() == 1 && wt.queue.Len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	close(wt.generator.finish)
+	require.Eventually(t, func() bool {
+		current, err := wt.dataStore.Reports.ByPrimaryKey(context.Background(), report.UserID, report.ID)
+		require.NoError(t, err)
+		return current.Status() == store.ReportStatusCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	require.Equal(t, int32(1), wt.generator.peak.Load())
+	current, err := wt.dataStore.Reports.ByPrimaryKey(context.Background(), report.UserID, report.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, current.Attempts)
+}
+
+func TestGenerateStreamsOutput(t *testing.T) {
+	ctx := context.Background()
+	blobStore, err := blobstore.NewLocalStore(t.TempDir(), "http://localhost", []byte("secret"))
+	require.NoError(t, err)
+
+	generator := &blockingGenerator{finish: make(chan struct{})}
+	close(generator.finish)
+	registry := reports.NewRegistry()
+	registry.MustRegister(generator)
+	worker := &Worker{blobStore: blobStore, registry: registry}
+
+	report := &store.Report{UserID: uuid.New(), ID: uuid.New(), ReportType: "blocking"}
+	output, err := worker.generate(ctx, report, reports.NoProgress)
+	require.NoError(t, err)
+	require.Equal(t, path.Join("reports", report.UserID.String(), report.ID.String()+".txt"), output.FilePath)
+	require.Equal(t, int64(4), output.Size)
+	sum := sha256.Sum256([]byte("done"))
+	require.Equal(t, hex.EncodeToString(sum[:]), output.Checksum)
+
+	info, err := blobStore.Stat(ctx, output.FilePath)
+	require.NoError(t, err)
+	require.Equal(t, output.Size, info.Size)
+
+	// a generator cancelled halfway leaves no output behind
+	generator = &blockingGenerator{finish: make(chan struct{})}
+	registry = reports.NewRegistry()
+	registry.MustRegister(generator)
+	worker.registry = registry
+	report = &store.Report{UserID: uuid.New(), ID: uuid.New(), ReportType: "blocking"}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = worker.generate(cancelled, report, reports.NoProgress)
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = blobStore.Stat(ctx, path.Join("reports", report.UserID.String(), report.ID.String()+".txt"))
+	require.ErrorIs(t, err, blobstore.ErrNotFound)
+}